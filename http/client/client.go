@@ -13,7 +13,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gpahal/golib/metrics"
 	"github.com/gpahal/golib/retry"
+	"github.com/gpahal/golib/tracing"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/net/publicsuffix"
 )
@@ -27,6 +29,9 @@ type Client struct {
 	baseUrl   *url.URL
 	header    http.Header
 	retryOpts retry.Options
+	trace     bool
+	tracer    tracing.Tracer
+	metrics   *clientMetrics
 }
 
 type Options struct {
@@ -36,6 +41,15 @@ type Options struct {
 	Header           http.Header
 	RetryOpts        retry.Options
 	IncludeCookieJar bool
+	// Trace enables capturing per-attempt timings which are exposed on
+	// Response.Timings.
+	Trace bool
+	// Tracer starts a client span for every call to Do and injects its W3C
+	// trace context into outgoing requests.
+	Tracer tracing.Tracer
+	// Metrics records per-host request counts, latencies, retries, error
+	// classes and connection pool usage.
+	Metrics metrics.Registry
 }
 
 func New() (*Client, error) {
@@ -62,18 +76,25 @@ func NewWithOptions(opts Options) (*Client, error) {
 		cookieJar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	}
 
+	var clientMetrics *clientMetrics
+	dialContext := (&net.Dialer{
+		Timeout: 10 * time.Second,
+	}).DialContext
+	if opts.Metrics != nil {
+		clientMetrics = newClientMetrics(opts.Metrics)
+		dialContext = clientMetrics.dialContext(dialContext)
+	}
+
 	httpClient := &http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout: 10 * time.Second,
-			}).Dial,
+			DialContext:         dialContext,
 			TLSHandshakeTimeout: 10 * time.Second,
 		},
 		Jar: cookieJar,
 	}
 
-	return &Client{client: httpClient, baseUrl: baseUrl, header: opts.Header, retryOpts: opts.RetryOpts}, nil
+	return &Client{client: httpClient, baseUrl: baseUrl, header: opts.Header, retryOpts: opts.RetryOpts, trace: opts.Trace, tracer: opts.Tracer, metrics: clientMetrics}, nil
 }
 
 type Request struct {
@@ -100,7 +121,9 @@ func (c Client) NewRequestWithContext(ctx context.Context, method, urlString str
 		return nil, err
 	}
 
-	httpReq.Header = c.header
+	if c.header != nil {
+		httpReq.Header = c.header.Clone()
+	}
 	return &Request{Request: httpReq}, nil
 }
 
@@ -178,6 +201,11 @@ func (req *Request) SetBodyForm(data url.Values) {
 
 type Response struct {
 	*http.Response
+	// Attempts is the number of attempts made, including retries.
+	Attempts int
+	// Timings holds the timing breakdown of every attempt. It is only set if
+	// the client was created with Options.Trace.
+	Timings []Timing
 }
 
 func (resp Response) GetHttpResponse() *http.Response {
@@ -207,10 +235,58 @@ func (resp Response) BindBodyJson(v any) error {
 }
 
 func (c Client) Do(req *Request) (*Response, error) {
+	baseReq := req.Request
+	var span tracing.Span
+	if c.tracer != nil {
+		var ctx context.Context
+		ctx, span = c.tracer.Start(baseReq.Context(), "HTTP "+baseReq.Method, tracing.SpanKindClient)
+		defer span.End()
+
+		span.SetAttribute("http.request.method", baseReq.Method)
+		span.SetAttribute("server.address", baseReq.URL.Host)
+		span.SetAttribute("url.full", baseReq.URL.String())
+		// The trace context is injected into a copy of the header so that
+		// the request of the caller is not modified.
+		baseReq = baseReq.WithContext(ctx)
+		baseReq.Header = baseReq.Header.Clone()
+		if baseReq.Header == nil {
+			baseReq.Header = make(http.Header)
+		}
+		tracing.Inject(ctx, baseReq.Header)
+	}
+
+	start := time.Now()
+	if c.metrics != nil {
+		c.metrics.inFlight.Inc(baseReq.URL.Host)
+		defer c.metrics.inFlight.Dec(baseReq.URL.Host)
+	}
+
 	var resp *Response
+	var timings []Timing
+	var lastTracer *attemptTracer
+	attempts := 0
 	err := retry.Do(func() error {
-		httpResp, err := c.client.Do(req.Request)
+		attempts += 1
+
+		httpReq := baseReq
+		if c.metrics != nil {
+			httpReq = c.metrics.withTrace(httpReq)
+		}
+		var tracer *attemptTracer
+		if c.trace {
+			tracer = newAttemptTracer()
+			httpReq = tracer.withTrace(httpReq)
+		}
+
+		httpResp, err := c.client.Do(httpReq)
+		if tracer != nil {
+			timings = append(timings, tracer.timing(err))
+			lastTracer = tracer
+		}
 		if err != nil {
+			if c.metrics != nil {
+				c.metrics.observeAttemptError(httpReq, err)
+			}
 			return err
 		}
 
@@ -218,5 +294,24 @@ func (c Client) Do(req *Request) (*Response, error) {
 		return nil
 	}, c.retryOpts)
 
+	if resp != nil {
+		resp.Attempts = attempts
+		resp.Timings = timings
+		if lastTracer != nil {
+			resp.Body = &timedBody{ReadCloser: resp.Body, start: lastTracer.start, total: &resp.Timings[len(timings)-1].Total}
+		}
+	}
+	if c.metrics != nil {
+		c.metrics.observe(baseReq, resp, start, attempts)
+	}
+	if span != nil {
+		span.SetAttribute("http.request.resend_count", attempts-1)
+		if resp != nil {
+			span.SetAttribute("http.response.status_code", resp.StatusCode)
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
 	return resp, err
 }
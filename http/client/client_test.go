@@ -0,0 +1,141 @@
+package client_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gpahal/golib/http/client"
+	"github.com/gpahal/golib/metrics"
+	"github.com/gpahal/golib/retry"
+)
+
+// newFlakyServer returns a server closing the connection of the first failures
+// requests without a response.
+func newFlakyServer(t *testing.T, failures int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func newRetryingClient(t *testing.T, baseUrl string, registry metrics.Registry) *client.Client {
+	t.Helper()
+	c, err := client.NewWithOptions(client.Options{
+		BaseUrlString: baseUrl,
+		RetryOpts: retry.Options{
+			Delayer: retry.FixedDelayer(time.Millisecond),
+			Stopper: retry.MaxAttemptsStopper(3),
+		},
+		Trace:   true,
+		Metrics: registry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDoRetries(t *testing.T) {
+	srv, requests := newFlakyServer(t, 2)
+	registry := metrics.NewRegistry()
+	c := newRetryingClient(t, srv.URL, registry)
+
+	req, err := c.NewRequest(http.MethodGet, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("body = %q, %v, want ok", body, err)
+	}
+
+	if n := requests.Load(); n != 3 {
+		t.Errorf("server requests = %d, want 3", n)
+	}
+	if resp.Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", resp.Attempts)
+	}
+	if len(resp.Timings) != 3 {
+		t.Fatalf("Timings = %d, want 3", len(resp.Timings))
+	}
+	for i, timing := range resp.Timings[:2] {
+		if timing.Err == nil {
+			t.Errorf("timing %d has no error", i)
+		}
+	}
+	last := resp.Timings[2]
+	if last.Err != nil || last.RemoteAddr == "" || last.FirstByte <= 0 || last.Total < last.FirstByte {
+		t.Errorf("last timing = %+v, want a successful attempt with its durations", last)
+	}
+
+	host := srv.Listener.Addr().String()
+	counters := []struct {
+		name   string
+		labels []string
+		want   float64
+	}{
+		{name: "http_client_requests_total", labels: []string{host, http.MethodGet, "2xx"}, want: 1},
+		{name: "http_client_retries_total", labels: []string{host, http.MethodGet}, want: 2},
+		{name: "http_client_errors_total", labels: []string{host, http.MethodGet, "other"}, want: 2},
+		{name: "http_client_connections_dialed_total", labels: []string{host}, want: 3},
+		{name: "http_client_requests_in_flight", labels: []string{host}, want: 0},
+	}
+	for _, tt := range counters {
+		if got := registry.Value(tt.name, tt.labels...); got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+	if got := registry.Count("http_client_request_duration_seconds", host, http.MethodGet, "2xx"); got != 1 {
+		t.Errorf("http_client_request_duration_seconds count = %d, want 1", got)
+	}
+}
+
+func TestDoRetriesExhausted(t *testing.T) {
+	srv, requests := newFlakyServer(t, 10)
+	registry := metrics.NewRegistry()
+	c := newRetryingClient(t, srv.URL, registry)
+
+	req, err := c.NewRequest(http.MethodPost, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("Do succeeded")
+	}
+
+	if n := requests.Load(); n != 3 {
+		t.Errorf("server requests = %d, want 3", n)
+	}
+	host := srv.Listener.Addr().String()
+	if got := registry.Value("http_client_requests_total", host, http.MethodPost, "error"); got != 1 {
+		t.Errorf("http_client_requests_total = %v, want 1", got)
+	}
+	if got := registry.Value("http_client_errors_total", host, http.MethodPost, "other"); got != 3 {
+		t.Errorf("http_client_errors_total = %v, want 3", got)
+	}
+	if got := registry.Count("http_client_request_duration_seconds", host, http.MethodPost, "error"); got != 1 {
+		t.Errorf("http_client_request_duration_seconds count = %d, want 1", got)
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gpahal/golib/metrics"
+)
+
+type clientMetrics struct {
+	requests      metrics.Counter
+	duration      metrics.Histogram
+	inFlight      metrics.Gauge
+	retries       metrics.Counter
+	errors        metrics.Counter
+	connsOpen     metrics.Gauge
+	connsAcquired metrics.Counter
+	connsDialed   metrics.Counter
+}
+
+func newClientMetrics(registry metrics.Registry) *clientMetrics {
+	return &clientMetrics{
+		requests:      registry.Counter("http_client_requests_total", "Total number of HTTP requests made, including retries as one request.", "host", "method", "status_class"),
+		duration:      registry.Histogram("http_client_request_duration_seconds", "Duration of HTTP requests in seconds, including retries.", metrics.DefaultBuckets, "host", "method", "status_class"),
+		inFlight:      registry.Gauge("http_client_requests_in_flight", "Number of HTTP requests currently in flight.", "host"),
+		retries:       registry.Counter("http_client_retries_total", "Total number of HTTP request retries.", "host", "method"),
+		errors:        registry.Counter("http_client_errors_total", "Total number of failed HTTP request attempts.", "host", "method", "error_class"),
+		connsOpen:     registry.Gauge("http_client_connections_open", "Number of open connections.", "address"),
+		connsAcquired: registry.Counter("http_client_connections_acquired_total", "Total number of connections acquired from the pool.", "host", "reused"),
+		connsDialed:   registry.Counter("http_client_connections_dialed_total", "Total number of connections dialed.", "address"),
+	}
+}
+
+func (m *clientMetrics) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		m.connsDialed.Inc(addr)
+		m.connsOpen.Inc(addr)
+		return &trackedConn{Conn: conn, onClose: func() { m.connsOpen.Dec(addr) }}, nil
+	}
+}
+
+func (m *clientMetrics) withTrace(req *http.Request) *http.Request {
+	host := req.URL.Host
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			m.connsAcquired.Inc(host, strconv.FormatBool(info.Reused))
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (m *clientMetrics) observeAttemptError(req *http.Request, err error) {
+	m.errors.Inc(req.URL.Host, req.Method, errorClass(err))
+}
+
+func (m *clientMetrics) observe(req *http.Request, resp *Response, start time.Time, attempts int) {
+	statusClass := "error"
+	if resp != nil {
+		statusClass = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+
+	host := req.URL.Host
+	m.requests.Inc(host, req.Method, statusClass)
+	m.duration.Observe(time.Since(start).Seconds(), host, req.Method, statusClass)
+	if attempts > 1 {
+		m.retries.Add(float64(attempts-1), host, req.Method)
+	}
+}
+
+// errorClass returns a coarse, low cardinality classification of a request
+// error.
+func errorClass(err error) string {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCertErr x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &unknownAuthErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidCertErr):
+		return "tls"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "connect"
+	default:
+		return "other"
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing is the timing breakdown of a single request attempt. Durations of
+// phases that did not happen (for example DNS and Connect on a reused
+// connection) are zero.
+type Timing struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// ServerProcessing is the time between the request being fully written
+	// and the first response byte being received.
+	ServerProcessing time.Duration
+	// FirstByte is the time between the start of the attempt and the first
+	// response byte being received.
+	FirstByte time.Duration
+	// Total is the time between the start of the attempt and the response
+	// body being read to EOF or closed. Until then, and for attempts without
+	// a response, it is the time until the response headers or the error.
+	Total time.Duration
+
+	ConnReused   bool
+	ConnWasIdle  bool
+	ConnIdleTime time.Duration
+	RemoteAddr   string
+	Err          error
+}
+
+type attemptTracer struct {
+	mu sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	connReused   bool
+	connWasIdle  bool
+	connIdleTime time.Duration
+	remoteAddr   string
+}
+
+func newAttemptTracer() *attemptTracer {
+	return &attemptTracer{start: time.Now()}
+}
+
+func (t *attemptTracer) withTrace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsDone = time.Now()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			// With multiple addresses (happy eyeballs) only the first dial start
+			// is kept.
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.connectDone = time.Now()
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsDone = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connReused = info.Reused
+			t.connWasIdle = info.WasIdle
+			t.connIdleTime = info.IdleTime
+			if info.Conn != nil {
+				t.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.firstByte = time.Now()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (t *attemptTracer) timing(err error) Timing {
+	end := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Timing{
+		DNS:              sinceIfSet(t.dnsStart, t.dnsDone),
+		Connect:          sinceIfSet(t.connectStart, t.connectDone),
+		TLSHandshake:     sinceIfSet(t.tlsStart, t.tlsDone),
+		ServerProcessing: sinceIfSet(t.wroteRequest, t.firstByte),
+		FirstByte:        sinceIfSet(t.start, t.firstByte),
+		Total:            end.Sub(t.start),
+		ConnReused:       t.connReused,
+		ConnWasIdle:      t.connWasIdle,
+		ConnIdleTime:     t.connIdleTime,
+		RemoteAddr:       t.remoteAddr,
+		Err:              err,
+	}
+}
+
+// timedBody sets the Total timing of the attempt of a response once its body
+// is read to EOF or closed.
+type timedBody struct {
+	io.ReadCloser
+	start time.Time
+	total *time.Duration
+	once  sync.Once
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+func (b *timedBody) done() {
+	b.once.Do(func() {
+		*b.total = time.Since(b.start)
+	})
+}
+
+func sinceIfSet(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
@@ -0,0 +1,188 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gpahal/golib/http/client"
+	"github.com/gpahal/golib/http/server"
+	"github.com/gpahal/golib/tracing"
+	"github.com/labstack/echo/v4"
+)
+
+func newTracedServer(t *testing.T, tracer tracing.Tracer, traceparent *string) *httptest.Server {
+	t.Helper()
+
+	e := server.NewWithOptions(server.Options{LoggerWriter: io.Discard, Tracer: tracer})
+	e.GET("/items/:id", func(c echo.Context) error {
+		*traceparent = c.Request().Header.Get(tracing.HeaderTraceparent)
+		return c.NoContent(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTracingRoundTrip(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	tracer := tracing.NewTracer(exporter)
+	var traceparent string
+	srv := newTracedServer(t, tracer, &traceparent)
+
+	c, err := client.NewWithOptions(client.Options{BaseUrlString: srv.URL, Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "root", tracing.SpanKindInternal)
+	req, err := c.NewRequestWithContext(ctx, http.MethodGet, "/items/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	root.End()
+
+	if got := req.Header.Get(tracing.HeaderTraceparent); got != "" {
+		t.Errorf("caller request traceparent = %q, want none", got)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("exported %d spans, want 3", len(spans))
+	}
+	spansByKind := make(map[tracing.SpanKind]tracing.SpanData)
+	for _, span := range spans {
+		spansByKind[span.Kind] = span
+	}
+	rootSpan := spansByKind[tracing.SpanKindInternal]
+	clientSpan := spansByKind[tracing.SpanKindClient]
+	serverSpan := spansByKind[tracing.SpanKindServer]
+
+	if want := tracing.FormatTraceparent(clientSpan.SpanContext); traceparent != want {
+		t.Errorf("received traceparent = %q, want %q", traceparent, want)
+	}
+	for _, span := range []tracing.SpanData{clientSpan, serverSpan} {
+		if span.SpanContext.TraceID != rootSpan.SpanContext.TraceID {
+			t.Errorf("%s span trace id = %s, want %s", span.Kind, span.SpanContext.TraceID, rootSpan.SpanContext.TraceID)
+		}
+	}
+	if clientSpan.ParentSpanID != rootSpan.SpanContext.SpanID {
+		t.Errorf("client span parent = %s, want %s", clientSpan.ParentSpanID, rootSpan.SpanContext.SpanID)
+	}
+	if serverSpan.ParentSpanID != clientSpan.SpanContext.SpanID {
+		t.Errorf("server span parent = %s, want %s", serverSpan.ParentSpanID, clientSpan.SpanContext.SpanID)
+	}
+	if serverSpan.Name != "GET /items/:id" {
+		t.Errorf("server span name = %q, want %q", serverSpan.Name, "GET /items/:id")
+	}
+	if got := serverSpan.Attributes["http.response.status_code"]; got != http.StatusNoContent {
+		t.Errorf("server span status = %v, want %d", got, http.StatusNoContent)
+	}
+}
+
+func TestTracingRemoteParent(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	var traceparent string
+	srv := newTracedServer(t, tracing.NewTracer(exporter), &traceparent)
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/items/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(tracing.HeaderTraceparent, incoming)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("exported %d spans, want 1", len(spans))
+	}
+	parent, _ := tracing.ParseTraceparent(incoming)
+	if spans[0].SpanContext.TraceID != parent.TraceID {
+		t.Errorf("server span trace id = %s, want %s", spans[0].SpanContext.TraceID, parent.TraceID)
+	}
+	if spans[0].ParentSpanID != parent.SpanID {
+		t.Errorf("server span parent = %s, want %s", spans[0].ParentSpanID, parent.SpanID)
+	}
+}
+
+func TestTracingWithoutTracer(t *testing.T) {
+	var traceparent string
+	srv := newTracedServer(t, nil, &traceparent)
+
+	c, err := client.NewWithOptions(client.Options{BaseUrlString: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, span := tracing.NewTracer(nil).Start(context.Background(), "root", tracing.SpanKindInternal)
+	defer span.End()
+	req, err := c.NewRequestWithContext(ctx, http.MethodGet, "/items/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := req.Header.Get(tracing.HeaderTraceparent); got != "" {
+		t.Errorf("caller request traceparent = %q, want none", got)
+	}
+	if traceparent != "" {
+		t.Errorf("received traceparent = %q, want none", traceparent)
+	}
+}
+
+func TestTracingContinuedWithoutServerTracer(t *testing.T) {
+	var traceparent string
+	downstream := newTracedServer(t, nil, &traceparent)
+	c, err := client.NewWithOptions(client.Options{BaseUrlString: downstream.URL, Tracer: tracing.NewTracer(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := server.NewWithOptions(server.Options{LoggerWriter: io.Discard})
+	e.GET("/", func(ec echo.Context) error {
+		req, err := c.NewRequestWithContext(ec.Request().Context(), http.MethodGet, "/items/1")
+		if err != nil {
+			return err
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return ec.NoContent(http.StatusNoContent)
+	})
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(tracing.HeaderTraceparent, incoming)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	parent, _ := tracing.ParseTraceparent(incoming)
+	got, err := tracing.ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatalf("received traceparent = %q: %v", traceparent, err)
+	}
+	if got.TraceID != parent.TraceID || got.SpanID == parent.SpanID {
+		t.Errorf("received traceparent = %q, want a child of %q", traceparent, incoming)
+	}
+}
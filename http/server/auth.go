@@ -0,0 +1,284 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	AuthMethodJWT     = "jwt"
+	AuthMethodAPIKey  = "api_key"
+	AuthMethodSession = "session"
+
+	defaultAPIKeyHeader = "X-API-Key"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("insufficient scope")
+	ErrInvalidAPIKey   = errors.New("invalid api key")
+)
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	// Method is the authentication method, e.g. AuthMethodJWT.
+	Method  string
+	Subject string
+	Scopes  []string
+	// Claims are the JWT claims of the principal, or other attributes.
+	Claims map[string]any
+}
+
+// HasScopes reports whether p has all the scopes.
+func (p *Principal) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		found := false
+		for _, s := range p.Scopes {
+			if s == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Authenticator authenticates requests.
+type Authenticator interface {
+	// Authenticate returns the principal of the request, nil if the request
+	// has no credentials for the authenticator, or an error if it has invalid
+	// credentials.
+	Authenticate(c echo.Context) (*Principal, error)
+}
+
+type AuthenticatorFunc func(c echo.Context) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(c echo.Context) (*Principal, error) {
+	return f(c)
+}
+
+// newAuthMiddleware returns a middleware which sets the principal of the
+// first authenticator recognizing the credentials of the request. Requests
+// with invalid credentials fail with a 401 error, and requests without
+// credentials continue without a principal, see RequireAuth.
+func newAuthMiddleware(authenticators []Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(c)
+				if err != nil {
+					var bearerErr *invalidBearerTokenError
+					if errors.As(err, &bearerErr) {
+						c.Response().Header().Set(web.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+					}
+					return NewHttpErrorWithInternal(http.StatusUnauthorized, "invalid credentials", err)
+				}
+				if principal != nil {
+					GetContext(c).Principal = principal
+					break
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireAuth returns a middleware rejecting requests without a principal
+// with a 401 error.
+func RequireAuth() echo.MiddlewareFunc {
+	return RequireScopes()
+}
+
+// RequireScopes returns a middleware rejecting requests without a principal
+// with a 401 error, and requests whose principal lacks any of the scopes
+// with a 403 error.
+func RequireScopes(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal := GetContext(c).Principal
+			if principal == nil {
+				c.Response().Header().Set(web.HeaderWWWAuthenticate, "Bearer")
+				return NewHttpErrorWithInternal(http.StatusUnauthorized, ErrUnauthenticated.Error(), ErrUnauthenticated)
+			}
+			if !principal.HasScopes(scopes...) {
+				c.Response().Header().Set(web.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+				return NewHttpErrorWithInternal(http.StatusForbidden, ErrForbidden.Error(), ErrForbidden)
+			}
+			return next(c)
+		}
+	}
+}
+
+// JWTAuthOptions configures a JWT bearer token authenticator.
+type JWTAuthOptions struct {
+	JWTOptions
+	// ScopeClaim is the claim holding the scopes of the principal, either a
+	// space separated string or an array of strings. It defaults to "scope",
+	// falling back to "scp".
+	ScopeClaim string
+}
+
+// NewJWTAuthenticator returns an authenticator of JWTs sent as bearer tokens
+// in the Authorization header.
+func NewJWTAuthenticator(opts JWTAuthOptions) Authenticator {
+	if opts.Keys == nil {
+		panic("server: jwt authenticator requires keys")
+	}
+
+	return AuthenticatorFunc(func(c echo.Context) (*Principal, error) {
+		token, ok := bearerToken(c.Request())
+		if !ok {
+			return nil, nil
+		}
+
+		claims, err := VerifyJWT(c.Request().Context(), token, opts.JWTOptions)
+		if err != nil {
+			return nil, &invalidBearerTokenError{err: err}
+		}
+		subject, _ := claims["sub"].(string)
+		return &Principal{Method: AuthMethodJWT, Subject: subject, Scopes: scopesClaim(claims, opts.ScopeClaim), Claims: claims}, nil
+	})
+}
+
+// invalidBearerTokenError is the error of an invalid bearer token, answered
+// with a WWW-Authenticate: Bearer error="invalid_token" header.
+type invalidBearerTokenError struct {
+	err error
+}
+
+func (e *invalidBearerTokenError) Error() string { return e.err.Error() }
+
+func (e *invalidBearerTokenError) Unwrap() error { return e.err }
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get(web.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func scopesClaim(claims map[string]any, name string) []string {
+	names := []string{name}
+	if name == "" {
+		names = []string{"scope", "scp"}
+	}
+	for _, name := range names {
+		if s, ok := claims[name].(string); ok {
+			return strings.Fields(s)
+		}
+		if scopes := stringsClaim(claims, name); scopes != nil {
+			return scopes
+		}
+	}
+	return nil
+}
+
+// APIKeyAuthOptions configures an API key authenticator. Keys map API keys to
+// their principals, and HashedKeys map hex encoded SHA-256 hashes of API
+// keys, see HashAPIKey, so that plaintext keys need not be stored.
+type APIKeyAuthOptions struct {
+	// Header defaults to X-API-Key.
+	Header     string
+	Keys       map[string]Principal
+	HashedKeys map[string]Principal
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash of key, for
+// APIKeyAuthOptions.HashedKeys. API keys must be random and long, so a fast
+// hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKeyAuthenticator returns an authenticator of API keys sent in a
+// header.
+func NewAPIKeyAuthenticator(opts APIKeyAuthOptions) Authenticator {
+	if opts.Header == "" {
+		opts.Header = defaultAPIKeyHeader
+	}
+
+	type hashedKey struct {
+		hash      []byte
+		principal Principal
+	}
+	keys := make([]hashedKey, 0, len(opts.Keys)+len(opts.HashedKeys))
+	for key, principal := range opts.Keys {
+		sum := sha256.Sum256([]byte(key))
+		keys = append(keys, hashedKey{hash: sum[:], principal: principal})
+	}
+	for hash, principal := range opts.HashedKeys {
+		b, err := hex.DecodeString(hash)
+		if err != nil || len(b) != sha256.Size {
+			panic("server: invalid hashed api key " + hash)
+		}
+		keys = append(keys, hashedKey{hash: b, principal: principal})
+	}
+
+	return AuthenticatorFunc(func(c echo.Context) (*Principal, error) {
+		key := c.Request().Header.Get(opts.Header)
+		if key == "" {
+			return nil, nil
+		}
+
+		sum := sha256.Sum256([]byte(key))
+		// All keys are compared so that the time taken does not depend on
+		// which key matched.
+		var match *Principal
+		for i := range keys {
+			if subtle.ConstantTimeCompare(sum[:], keys[i].hash) == 1 {
+				match = &keys[i].principal
+			}
+		}
+		if match == nil {
+			return nil, ErrInvalidAPIKey
+		}
+
+		principal := *match
+		principal.Method = AuthMethodAPIKey
+		return &principal, nil
+	})
+}
+
+// SessionAuthOptions configures a cookie session authenticator.
+type SessionAuthOptions struct {
+	CookieName string
+	// Lookup returns the principal of the session id, or nil if there is no
+	// such session.
+	Lookup func(c echo.Context, sessionID string) (*Principal, error)
+}
+
+// NewSessionAuthenticator returns an authenticator of session ids sent in a
+// cookie. Requests with unknown sessions continue unauthenticated so that
+// clients can sign in again.
+func NewSessionAuthenticator(opts SessionAuthOptions) Authenticator {
+	if opts.CookieName == "" || opts.Lookup == nil {
+		panic("server: session authenticator requires a cookie name and a lookup")
+	}
+
+	return AuthenticatorFunc(func(c echo.Context) (*Principal, error) {
+		cookie, err := c.Cookie(opts.CookieName)
+		if err != nil || cookie.Value == "" {
+			return nil, nil
+		}
+
+		principal, err := opts.Lookup(c, cookie.Value)
+		if err != nil || principal == nil {
+			return nil, err
+		}
+		principal.Method = AuthMethodSession
+		return principal, nil
+	})
+}
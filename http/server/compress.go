@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	web "github.com/gpahal/golib/http"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/pkg/errors"
+)
+
+const (
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+
+	defaultCompressionMinSize = 1024
+)
+
+var (
+	defaultCompressionEncodings    = []string{EncodingBrotli, EncodingZstd, EncodingGzip, EncodingDeflate}
+	defaultCompressionContentTypes = []string{
+		"text/*",
+		web.MIMEApplicationJSON,
+		MIMEApplicationProblemJSON,
+		web.MIMEApplicationJavaScript,
+		web.MIMEApplicationXML,
+		"application/wasm",
+		"image/svg+xml",
+	}
+)
+
+// CompressionOptions configures the compression of responses.
+type CompressionOptions struct {
+	// Encodings are the supported encodings in order of preference when
+	// clients accept several equally, defaulting to br, zstd, gzip and
+	// deflate.
+	Encodings []string
+	// MinSize is the size of the smallest response compressed, defaulting to
+	// 1KB. Smaller responses are not worth the overhead.
+	MinSize int
+	// ContentTypes are the media types compressed, e.g. "application/json",
+	// or "text/*" for all subtypes. They default to text, JSON, JavaScript,
+	// XML, WebAssembly and SVG.
+	ContentTypes []string
+	Skipper      middleware.Skipper
+}
+
+type compressionEncoder struct {
+	pool sync.Pool
+}
+
+func (e *compressionEncoder) get(w io.Writer) compressionWriter {
+	cw := e.pool.Get().(compressionWriter)
+	cw.Reset(w)
+	return cw
+}
+
+func (e *compressionEncoder) put(cw compressionWriter) {
+	e.pool.Put(cw)
+}
+
+type compressionWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var (
+	compressionEncoders = map[string]*compressionEncoder{
+		EncodingBrotli: {pool: sync.Pool{New: func() any {
+			return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+		}}},
+		EncodingZstd: {pool: sync.Pool{New: func() any {
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+			return w
+		}}},
+		EncodingGzip: {pool: sync.Pool{New: func() any {
+			return gzip.NewWriter(nil)
+		}}},
+		// The deflate content coding is the zlib format, see RFC 9110.
+		EncodingDeflate: {pool: sync.Pool{New: func() any {
+			return zlib.NewWriter(nil)
+		}}},
+	}
+)
+
+// newCompressionMiddleware returns a middleware compressing responses with
+// the encoding negotiated from the Accept-Encoding header. Responses are
+// buffered up to MinSize to decide whether to compress them. Responses that
+// are already encoded, are partial, have an empty body or have a content type
+// outside of ContentTypes are sent as is. Error responses written by the error handler
+// are not compressed.
+func newCompressionMiddleware(opts CompressionOptions) echo.MiddlewareFunc {
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = defaultCompressionEncodings
+	}
+	for _, encoding := range opts.Encodings {
+		if _, ok := compressionEncoders[encoding]; !ok {
+			panic("server: unsupported compression encoding " + encoding)
+		}
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = defaultCompressionMinSize
+	}
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = defaultCompressionContentTypes
+	}
+	if opts.Skipper == nil {
+		opts.Skipper = middleware.DefaultSkipper
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if opts.Skipper(c) || c.Request().Method == http.MethodHead {
+				return next(c)
+			}
+
+			res := c.Response()
+			addVary(res.Header(), web.HeaderAcceptEncoding)
+			encoding := negotiateEncoding(c.Request().Header.Get(web.HeaderAcceptEncoding), opts.Encodings)
+			if encoding == "" {
+				return next(c)
+			}
+
+			cw := &compressResponseWriter{ResponseWriter: res.Writer, encoding: encoding, opts: &opts}
+			res.Writer = cw
+			defer func() {
+				res.Writer = cw.ResponseWriter
+			}()
+
+			err := next(c)
+			if closeErr := cw.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+			return err
+		}
+	}
+}
+
+// negotiateEncoding returns the encoding of encodings with the highest
+// quality in acceptEncoding, preferring earlier encodings on ties, or an
+// empty string if identity is preferred.
+func negotiateEncoding(acceptEncoding string, encodings []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcardQ := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if qs, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(qs, 64); err == nil {
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcardQ = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = wildcardQ
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func isCompressibleContentType(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == a {
+			return true
+		}
+	}
+	return false
+}
+
+// compressResponseWriter buffers the response until it is known whether it
+// is to be compressed.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	opts     *CompressionOptions
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	cw      compressionWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	// Informational responses, e.g. 103 Early Hints, are sent right away.
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		w.status = 0
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.cw != nil {
+			return w.cw.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf.Write(b)
+	if w.buf.Len() >= w.opts.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the header of the response, compressing the response if
+// large is set and it is compressible, and the buffered body.
+func (w *compressResponseWriter) decide(large bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get(web.HeaderContentType) == "" && w.buf.Len() > 0 {
+		header.Set(web.HeaderContentType, http.DetectContentType(w.buf.Bytes()))
+	}
+
+	// Ranges of the identity response cannot be encoded on their own.
+	if large && header.Get(web.HeaderContentEncoding) == "" && header.Get(web.HeaderContentRange) == "" &&
+		w.status != http.StatusNoContent && w.status != http.StatusPartialContent && w.status != http.StatusNotModified &&
+		isCompressibleContentType(header.Get(web.HeaderContentType), w.opts.ContentTypes) {
+		header.Del(web.HeaderContentLength)
+		header.Set(web.HeaderContentEncoding, w.encoding)
+		// Strong validators of the identity response do not match the
+		// encoded one.
+		if etag := header.Get(web.HeaderETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set(web.HeaderETag, "W/"+etag)
+		}
+		w.cw = compressionEncoders[w.encoding].get(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.cw != nil {
+		_, err = w.cw.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// Flush sends the buffered response, compressed if it is compressible even
+// if smaller than MinSize, as streamed responses are usually large.
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if w.cw != nil {
+		if err := w.cw.Flush(); err != nil {
+			return
+		}
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 {
+			// Nothing was written, e.g. the handler returned an error.
+			return nil
+		}
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.cw == nil {
+		return nil
+	}
+
+	err := w.cw.Close()
+	compressionEncoders[w.encoding].put(w.cw)
+	w.cw = nil
+	return errors.Wrap(err, "compressing response")
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// isCompressing reports whether responses written to w go through the
+// compression middleware.
+func isCompressing(w http.ResponseWriter) bool {
+	for {
+		switch ww := w.(type) {
+		case *compressResponseWriter:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/klauspost/compress/gzip"
+	"github.com/labstack/echo/v4"
+)
+
+func decodeTestResponse(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	switch encoding := rec.Header().Get(web.HeaderContentEncoding); encoding {
+	case "":
+		return rec.Body.String()
+	case EncodingGzip:
+		r, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	default:
+		t.Fatalf("unexpected encoding %q", encoding)
+		return ""
+	}
+}
+
+func TestCompressionIdempotencyReplay(t *testing.T) {
+	e := NewWithOptions(Options{
+		LoggerWriter: io.Discard,
+		Compression:  &CompressionOptions{},
+		Idempotency:  &IdempotencyOptions{Store: NewMemoryIdempotencyStore()},
+	})
+	body := `{"data":"` + strings.Repeat("a", 2*defaultCompressionMinSize) + `"}`
+	var calls atomic.Int32
+	e.POST("/orders", func(c echo.Context) error {
+		calls.Add(1)
+		c.Response().Header().Set(web.HeaderETag, `"v1"`)
+		return c.Blob(http.StatusCreated, web.MIMEApplicationJSON, []byte(body))
+	})
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+		wantETag       string
+	}{
+		{name: "first", acceptEncoding: "br;q=0.5, gzip", wantEncoding: EncodingGzip, wantETag: `W/"v1"`},
+		{name: "replay without compression", wantETag: `"v1"`},
+		{name: "replay with compression", acceptEncoding: "gzip", wantEncoding: EncodingGzip, wantETag: `W/"v1"`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+		req.Header.Set(web.HeaderIdempotencyKey, "k")
+		if tt.acceptEncoding != "" {
+			req.Header.Set(web.HeaderAcceptEncoding, tt.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		header := rec.Header()
+		if rec.Code != http.StatusCreated {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, http.StatusCreated)
+		}
+		if got := header.Get(web.HeaderContentEncoding); got != tt.wantEncoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", tt.name, got, tt.wantEncoding)
+		}
+		if got := header.Get(web.HeaderETag); got != tt.wantETag {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, got, tt.wantETag)
+		}
+		if got := header.Get(web.HeaderContentLength); got != "" && tt.wantEncoding != "" {
+			t.Errorf("%s: Content-Length = %q for an encoded response", tt.name, got)
+		}
+		if got := decodeTestResponse(t, rec); got != body {
+			t.Errorf("%s: body of %d bytes does not match", tt.name, len(got))
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler calls = %d, want 1", n)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encodings      []string
+		want           string
+	}{
+		{acceptEncoding: "", encodings: defaultCompressionEncodings, want: ""},
+		{acceptEncoding: "gzip", encodings: defaultCompressionEncodings, want: EncodingGzip},
+		{acceptEncoding: "GZIP", encodings: defaultCompressionEncodings, want: EncodingGzip},
+		{acceptEncoding: "gzip, deflate, br", encodings: defaultCompressionEncodings, want: EncodingBrotli},
+		{acceptEncoding: "gzip, deflate, br", encodings: []string{EncodingGzip, EncodingBrotli}, want: EncodingGzip},
+		{acceptEncoding: "br;q=0.5, gzip;q=0.8", encodings: defaultCompressionEncodings, want: EncodingGzip},
+		{acceptEncoding: "br; q=0.5, gzip ;q=0.8", encodings: defaultCompressionEncodings, want: EncodingGzip},
+		{acceptEncoding: "br;q=0, gzip;q=0", encodings: defaultCompressionEncodings, want: ""},
+		{acceptEncoding: "identity", encodings: defaultCompressionEncodings, want: ""},
+		{acceptEncoding: "*", encodings: defaultCompressionEncodings, want: EncodingBrotli},
+		{acceptEncoding: "*;q=0.1, zstd", encodings: defaultCompressionEncodings, want: EncodingZstd},
+		{acceptEncoding: "*, br;q=0", encodings: defaultCompressionEncodings, want: EncodingZstd},
+		{acceptEncoding: "*;q=0", encodings: defaultCompressionEncodings, want: ""},
+		{acceptEncoding: "gzip;q=invalid", encodings: defaultCompressionEncodings, want: EncodingGzip},
+		{acceptEncoding: "compress", encodings: defaultCompressionEncodings, want: ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding, tt.encodings); got != tt.want {
+			t.Errorf("negotiateEncoding(%q, %v) = %q, want %q", tt.acceptEncoding, tt.encodings, got, tt.want)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat("a", 2*defaultCompressionMinSize)
+	tests := []struct {
+		name         string
+		method       string
+		handler      echo.HandlerFunc
+		wantEncoding string
+	}{
+		{
+			name: "large",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, large)
+			},
+			wantEncoding: EncodingGzip,
+		},
+		{
+			name: "small",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, "small")
+			},
+		},
+		{
+			name:   "head",
+			method: http.MethodHead,
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, large)
+			},
+		},
+		{
+			name: "incompressible content type",
+			handler: func(c echo.Context) error {
+				return c.Blob(http.StatusOK, "image/png", []byte(large))
+			},
+		},
+		{
+			name: "already encoded",
+			handler: func(c echo.Context) error {
+				c.Response().Header().Set(web.HeaderContentEncoding, EncodingBrotli)
+				return c.String(http.StatusOK, large)
+			},
+			wantEncoding: EncodingBrotli,
+		},
+		{
+			name: "partial content",
+			handler: func(c echo.Context) error {
+				c.Response().Header().Set(web.HeaderContentRange, "bytes 0-2047/4096")
+				return c.String(http.StatusPartialContent, large)
+			},
+		},
+		{
+			name: "content range",
+			handler: func(c echo.Context) error {
+				c.Response().Header().Set(web.HeaderContentRange, "bytes */4096")
+				return c.String(http.StatusRequestedRangeNotSatisfiable, large)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewWithOptions(Options{LoggerWriter: io.Discard, Compression: &CompressionOptions{}})
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			e.Add(method, "/", tt.handler)
+
+			req := httptest.NewRequest(method, "/", nil)
+			req.Header.Set(web.HeaderAcceptEncoding, "gzip")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get(web.HeaderContentEncoding); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rec.Header().Get(web.HeaderVary); got != web.HeaderAcceptEncoding && method != http.MethodHead {
+				t.Errorf("Vary = %q, want %q", got, web.HeaderAcceptEncoding)
+			}
+		})
+	}
+}
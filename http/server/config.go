@@ -0,0 +1,57 @@
+package server
+
+import (
+	"reflect"
+
+	"github.com/gpahal/golib/config"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// Config returns the server config as a T. The config can be stored as a T or
+// a *T; an error is returned if it is missing or of another type.
+func Config[T any](c echo.Context) (T, error) {
+	var zero T
+	raw := GetContext(c).ConfigRaw
+	switch v := raw.(type) {
+	case T:
+		return v, nil
+	case *T:
+		if v != nil {
+			return *v, nil
+		}
+	}
+
+	if raw == nil {
+		return zero, errors.Errorf("server config is not set, expected %s", reflect.TypeFor[T]())
+	}
+	return zero, errors.Errorf("server config has type %T, expected %s", raw, reflect.TypeFor[T]())
+}
+
+// MustConfig is like Config but panics if the config is missing or of another
+// type.
+func MustConfig[T any](c echo.Context) T {
+	cfg, err := Config[T](c)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// NewWithConfig is like NewWithOptions but takes the config with its type, so
+// that handlers can read it with Config[C] or MustConfig[C].
+func NewWithConfig[C any](cfg C, opts Options) *Server {
+	opts.Config = cfg
+	return NewWithOptions(opts)
+}
+
+// NewWithConfigFile loads a config of type C from configFilePath using the
+// config package, validating it with opts.Validator, and creates a server
+// with it like NewWithConfig.
+func NewWithConfigFile[C any](configFilePath string, opts Options) (*Server, C, error) {
+	var cfg C
+	if err := config.LoadWithOptions(configFilePath, &cfg, config.LoadOptions{Validator: opts.Validator}); err != nil {
+		return nil, cfg, err
+	}
+	return NewWithConfig(cfg, opts), cfg, nil
+}
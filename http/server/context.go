@@ -3,23 +3,72 @@ package server
 import (
 	"io"
 
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
+	"github.com/gpahal/golib/tracing"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog"
 )
 
 type Context struct {
 	echo.Context
-	Validator          *validator.Validate
+	Validator *validator.Validate
+	// Translator is the translator negotiated from the Accept-Language header
+	// of the request. It is nil if the server has no UniversalTranslator.
+	Translator         ut.Translator
 	ConfigRaw          any
 	ServerLoggerWriter io.Writer
 	ServerLogger       *zerolog.Logger
+	// Span is the server span of the request. It is nil if the server has no
+	// tracer.
+	Span tracing.Span
+	// SpanContext is the span context of Span, or the remote span context
+	// extracted from the request if the server has no tracer.
+	SpanContext tracing.SpanContext
+
+	// Services are the services registered with the server, see Service.
+	Services *Services
+
+	// ClientIP, ClientScheme and ClientHost are the IP, scheme and host of
+	// the client as resolved from the forwarding headers of trusted proxies,
+	// see Options.TrustedProxies.
+	ClientIP     string
+	ClientScheme string
+	ClientHost   string
+
+	// Principal is the authenticated client of the request, or nil if the
+	// request is not authenticated.
+	Principal *Principal
+	// Session is the session of the request, or nil if the server has no
+	// sessions.
+	Session *Session
+
+	// CSPNonce is the nonce of the Content-Security-Policy of the response,
+	// to be set as the nonce attribute of inline scripts and styles. It is
+	// empty if the policy has no CSPNonce source.
+	CSPNonce string
+	// CSRFToken is the CSRF token to be sent back with unsafe requests, e.g.
+	// in a hidden form field. It is empty if the server has no CSRF
+	// protection.
+	CSRFToken string
+
+	server            *Server
+	errorMapper       ErrorMapper
+	scopedServices    *scopedServices
+	idempotentRequest *idempotentRequest
 }
 
 func GetContext(c echo.Context) *Context {
 	return c.(*Context)
 }
 
+// Scheme returns the scheme of the client, see ClientScheme. Unlike the
+// echo.Context implementation, it ignores forwarding headers of untrusted
+// peers.
+func (c *Context) Scheme() string {
+	return c.ClientScheme
+}
+
 func (c *Context) Logger() echo.Logger {
 	return newGommonLogger(c.ServerLogger, c.ServerLoggerWriter)
 }
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	defaultCORSAllowMethods = []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete}
+)
+
+// CORSPolicy is the cross-origin resource sharing policy of the server or of
+// a route.
+type CORSPolicy struct {
+	// AllowOrigins are the allowed origins. An origin is either matched
+	// exactly, e.g. "https://example.com", or with a single wildcard, e.g.
+	// "https://*.example.com". "*" allows all origins and cannot be combined
+	// with AllowCredentials.
+	AllowOrigins []string
+	// AllowOriginPatterns are regular expressions matched against the whole
+	// origin.
+	AllowOriginPatterns []string
+	// AllowOriginFunc allows origins not matched by AllowOrigins and
+	// AllowOriginPatterns.
+	AllowOriginFunc func(origin string) bool
+
+	// AllowMethods defaults to GET, HEAD, PUT, PATCH, POST and DELETE.
+	AllowMethods []string
+	// AllowHeaders are the allowed request headers, matched case
+	// insensitively. Preflight requests for other headers are rejected. If
+	// empty, the headers requested by preflight requests are allowed.
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	// MaxAge is how long preflight responses can be cached. It is left to
+	// the browser default if zero, and a negative duration disables caching.
+	MaxAge time.Duration
+}
+
+type corsPolicy struct {
+	allowAllOrigins  bool
+	exactOrigins     map[string]struct{}
+	wildcardOrigins  [][2]string
+	patternOrigins   []*regexp.Regexp
+	allowOriginFunc  func(origin string) bool
+	allowMethods     []string
+	allowHeaderSet   map[string]struct{}
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+// compileCORSPolicy compiles p. It panics if p is invalid, like route
+// registration does.
+func compileCORSPolicy(p *CORSPolicy) *corsPolicy {
+	if p == nil {
+		return nil
+	}
+
+	cp := &corsPolicy{
+		exactOrigins:     make(map[string]struct{}),
+		allowOriginFunc:  p.AllowOriginFunc,
+		allowMethods:     p.AllowMethods,
+		allowHeaders:     strings.Join(p.AllowHeaders, ", "),
+		exposeHeaders:    strings.Join(p.ExposeHeaders, ", "),
+		allowCredentials: p.AllowCredentials,
+	}
+	for _, origin := range p.AllowOrigins {
+		switch {
+		case origin == "*":
+			if p.AllowCredentials {
+				panic("server: cors: wildcard origin \"*\" cannot be used with credentials")
+			}
+			cp.allowAllOrigins = true
+		case strings.Count(origin, "*") == 1:
+			i := strings.IndexByte(origin, '*')
+			cp.wildcardOrigins = append(cp.wildcardOrigins, [2]string{strings.ToLower(origin[:i]), strings.ToLower(origin[i+1:])})
+		case strings.Contains(origin, "*"):
+			panic("server: cors: origin " + strconv.Quote(origin) + " has more than one wildcard")
+		default:
+			cp.exactOrigins[strings.ToLower(origin)] = struct{}{}
+		}
+	}
+	if len(p.AllowHeaders) > 0 {
+		cp.allowHeaderSet = make(map[string]struct{}, len(p.AllowHeaders))
+		for _, h := range p.AllowHeaders {
+			cp.allowHeaderSet[strings.ToLower(h)] = struct{}{}
+		}
+	}
+	for _, pattern := range p.AllowOriginPatterns {
+		cp.patternOrigins = append(cp.patternOrigins, regexp.MustCompile("^(?:"+pattern+")$"))
+	}
+	if len(cp.allowMethods) == 0 {
+		cp.allowMethods = defaultCORSAllowMethods
+	}
+	if p.MaxAge > 0 {
+		cp.maxAge = strconv.FormatInt(int64(p.MaxAge/time.Second), 10)
+	} else if p.MaxAge < 0 {
+		cp.maxAge = "0"
+	}
+	return cp
+}
+
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.allowAllOrigins {
+		return true
+	}
+
+	lowerOrigin := strings.ToLower(origin)
+	if _, ok := p.exactOrigins[lowerOrigin]; ok {
+		return true
+	}
+	for _, w := range p.wildcardOrigins {
+		if len(lowerOrigin) > len(w[0])+len(w[1]) && strings.HasPrefix(lowerOrigin, w[0]) && strings.HasSuffix(lowerOrigin, w[1]) {
+			return true
+		}
+	}
+	for _, re := range p.patternOrigins {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return p.allowOriginFunc != nil && p.allowOriginFunc(origin)
+}
+
+func (p *corsPolicy) allowsMethod(method string) bool {
+	for _, m := range p.allowMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether all the headers of the comma separated
+// requestHeaders of a preflight request are allowed.
+func (p *corsPolicy) allowsHeaders(requestHeaders string) bool {
+	if p.allowHeaderSet == nil {
+		return true
+	}
+	for _, h := range strings.Split(requestHeaders, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if _, ok := p.allowHeaderSet[h]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// newCORSMiddleware returns a middleware which applies the policy configured
+// for the matched route in routePolicies, falling back to policy. A nil route
+// policy disables CORS for the route. Preflight requests are answered
+// without calling the handler.
+func newCORSMiddleware(policy *CORSPolicy, routePolicies map[string]*CORSPolicy) echo.MiddlewareFunc {
+	defaultPolicy := compileCORSPolicy(policy)
+	compiledRoutePolicies := make(map[string]*corsPolicy, len(routePolicies))
+	for path, p := range routePolicies {
+		compiledRoutePolicies[path] = compileCORSPolicy(p)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := defaultPolicy
+			if routePolicy, ok := compiledRoutePolicies[c.Path()]; ok {
+				p = routePolicy
+			}
+			if p == nil {
+				return next(c)
+			}
+
+			req := c.Request()
+			header := c.Response().Header()
+			preflight := req.Method == http.MethodOptions && req.Header.Get(web.HeaderAccessControlRequestMethod) != ""
+			// Responses depend on the origin unless all origins get the same
+			// response, so caches must key on it even if the origin is not
+			// allowed or missing.
+			if !p.allowAllOrigins {
+				addVary(header, web.HeaderOrigin)
+			}
+
+			origin := req.Header.Get(web.HeaderOrigin)
+			if origin == "" {
+				return next(c)
+			}
+			if !preflight {
+				if p.allowsOrigin(origin) {
+					setCORSAllowOrigin(header, p, origin)
+					if p.exposeHeaders != "" {
+						header.Set(web.HeaderAccessControlExposeHeaders, p.exposeHeaders)
+					}
+				}
+				return next(c)
+			}
+
+			addVary(header, web.HeaderAccessControlRequestMethod, web.HeaderAccessControlRequestHeaders)
+			requestHeaders := req.Header.Get(web.HeaderAccessControlRequestHeaders)
+			if !p.allowsOrigin(origin) || !p.allowsMethod(req.Header.Get(web.HeaderAccessControlRequestMethod)) || !p.allowsHeaders(requestHeaders) {
+				// Without CORS headers the browser fails the preflight request.
+				return c.NoContent(http.StatusNoContent)
+			}
+
+			setCORSAllowOrigin(header, p, origin)
+			header.Set(web.HeaderAccessControlAllowMethods, strings.Join(p.allowMethods, ", "))
+			if p.allowHeaders != "" {
+				header.Set(web.HeaderAccessControlAllowHeaders, p.allowHeaders)
+			} else if requestHeaders != "" {
+				header.Set(web.HeaderAccessControlAllowHeaders, requestHeaders)
+			}
+			if p.maxAge != "" {
+				header.Set(web.HeaderAccessControlMaxAge, p.maxAge)
+			}
+			return c.NoContent(http.StatusNoContent)
+		}
+	}
+}
+
+func setCORSAllowOrigin(header http.Header, p *corsPolicy, origin string) {
+	if p.allowAllOrigins {
+		header.Set(web.HeaderAccessControlAllowOrigin, "*")
+	} else {
+		header.Set(web.HeaderAccessControlAllowOrigin, origin)
+	}
+	if p.allowCredentials {
+		header.Set(web.HeaderAccessControlAllowCredentials, "true")
+	}
+}
+
+// addVary adds names to the Vary header of a response, skipping those it
+// already has.
+func addVary(header http.Header, names ...string) {
+	for _, name := range names {
+		found := false
+		for _, value := range header.Values(web.HeaderVary) {
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, name) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			header.Add(web.HeaderVary, name)
+		}
+	}
+}
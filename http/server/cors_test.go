@@ -0,0 +1,344 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+func TestCORS(t *testing.T) {
+	policy := &CORSPolicy{
+		AllowOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowOriginPatterns: []string{`https://app-[0-9]+\.example\.net`},
+		AllowMethods:        []string{http.MethodGet, http.MethodPost},
+		AllowHeaders:        []string{"Content-Type", "X-Custom"},
+		ExposeHeaders:       []string{"X-Total"},
+		AllowCredentials:    true,
+		MaxAge:              10 * time.Minute,
+	}
+	routePolicies := map[string]*CORSPolicy{
+		"/public":  {AllowOrigins: []string{"*"}},
+		"/private": nil,
+	}
+
+	e := NewWithOptions(Options{
+		LoggerWriter: io.Discard,
+		CORS:         policy,
+		RouteCORS:    routePolicies,
+		Compression:  &CompressionOptions{MinSize: 1},
+	})
+	handler := func(c echo.Context) error {
+		addVary(c.Response().Header(), web.HeaderCookie)
+		return c.String(http.StatusOK, "ok")
+	}
+	for _, path := range []string{"/items", "/public", "/private"} {
+		e.GET(path, handler)
+		e.POST(path, handler)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		// want are the expected response headers, an empty value meaning the
+		// header must not be set.
+		want       map[string]string
+		wantVary   []string
+		wantStatus int
+	}{
+		{
+			name:    "allowed origin",
+			method:  http.MethodGet,
+			path:    "/items",
+			headers: map[string]string{web.HeaderOrigin: "https://example.com"},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:      "https://example.com",
+				web.HeaderAccessControlAllowCredentials: "true",
+				web.HeaderAccessControlExposeHeaders:    "X-Total",
+			},
+			wantVary: []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+		},
+		{
+			name:     "allowed origin case insensitive",
+			method:   http.MethodGet,
+			path:     "/items",
+			headers:  map[string]string{web.HeaderOrigin: "https://EXAMPLE.com"},
+			want:     map[string]string{web.HeaderAccessControlAllowOrigin: "https://EXAMPLE.com"},
+			wantVary: []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+		},
+		{
+			name:     "wildcard subdomain origin",
+			method:   http.MethodGet,
+			path:     "/items",
+			headers:  map[string]string{web.HeaderOrigin: "https://api.example.org"},
+			want:     map[string]string{web.HeaderAccessControlAllowOrigin: "https://api.example.org"},
+			wantVary: []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+		},
+		{
+			name:     "wildcard does not match bare domain",
+			method:   http.MethodGet,
+			path:     "/items",
+			headers:  map[string]string{web.HeaderOrigin: "https://.example.org"},
+			want:     map[string]string{web.HeaderAccessControlAllowOrigin: ""},
+			wantVary: []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+		},
+		{
+			name:     "pattern origin",
+			method:   http.MethodGet,
+			path:     "/items",
+			headers:  map[string]string{web.HeaderOrigin: "https://app-42.example.net"},
+			want:     map[string]string{web.HeaderAccessControlAllowOrigin: "https://app-42.example.net"},
+			wantVary: []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+		},
+		{
+			name:    "pattern is anchored",
+			method:  http.MethodGet,
+			path:    "/items",
+			headers: map[string]string{web.HeaderOrigin: "https://app-42.example.net.evil.com"},
+			want:    map[string]string{web.HeaderAccessControlAllowOrigin: ""},
+		},
+		{
+			name:    "disallowed origin",
+			method:  http.MethodGet,
+			path:    "/items",
+			headers: map[string]string{web.HeaderOrigin: "https://evil.com"},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:      "",
+				web.HeaderAccessControlAllowCredentials: "",
+				web.HeaderAccessControlExposeHeaders:    "",
+			},
+			wantVary:   []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "no origin",
+			method:     http.MethodGet,
+			path:       "/items",
+			want:       map[string]string{web.HeaderAccessControlAllowOrigin: ""},
+			wantVary:   []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			path:   "/items",
+			headers: map[string]string{
+				web.HeaderOrigin:                      "https://example.com",
+				web.HeaderAccessControlRequestMethod:  http.MethodPost,
+				web.HeaderAccessControlRequestHeaders: "content-type, x-custom",
+			},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:      "https://example.com",
+				web.HeaderAccessControlAllowCredentials: "true",
+				web.HeaderAccessControlAllowMethods:     "GET, POST",
+				web.HeaderAccessControlAllowHeaders:     "Content-Type, X-Custom",
+				web.HeaderAccessControlMaxAge:           "600",
+				web.HeaderAccessControlExposeHeaders:    "",
+			},
+			wantVary:   []string{web.HeaderOrigin, web.HeaderAccessControlRequestMethod, web.HeaderAccessControlRequestHeaders},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:   "preflight disallowed origin",
+			method: http.MethodOptions,
+			path:   "/items",
+			headers: map[string]string{
+				web.HeaderOrigin:                     "https://evil.com",
+				web.HeaderAccessControlRequestMethod: http.MethodPost,
+			},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:  "",
+				web.HeaderAccessControlAllowMethods: "",
+			},
+			wantVary:   []string{web.HeaderOrigin, web.HeaderAccessControlRequestMethod, web.HeaderAccessControlRequestHeaders},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:   "preflight disallowed method",
+			method: http.MethodOptions,
+			path:   "/items",
+			headers: map[string]string{
+				web.HeaderOrigin:                     "https://example.com",
+				web.HeaderAccessControlRequestMethod: http.MethodDelete,
+			},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:  "",
+				web.HeaderAccessControlAllowMethods: "",
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:   "preflight disallowed header",
+			method: http.MethodOptions,
+			path:   "/items",
+			headers: map[string]string{
+				web.HeaderOrigin:                      "https://example.com",
+				web.HeaderAccessControlRequestMethod:  http.MethodPost,
+				web.HeaderAccessControlRequestHeaders: "Content-Type, X-Other",
+			},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:  "",
+				web.HeaderAccessControlAllowHeaders: "",
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:   "options without request method is not a preflight",
+			method: http.MethodOptions,
+			path:   "/items",
+			headers: map[string]string{
+				web.HeaderOrigin: "https://example.com",
+			},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:  "https://example.com",
+				web.HeaderAccessControlAllowMethods: "",
+			},
+		},
+		{
+			name:    "vary merged with other middleware and handler",
+			method:  http.MethodGet,
+			path:    "/items",
+			headers: map[string]string{web.HeaderOrigin: "https://example.com", web.HeaderAcceptEncoding: "gzip"},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin: "https://example.com",
+				web.HeaderContentEncoding:          "gzip",
+			},
+			wantVary: []string{web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderCookie},
+		},
+		{
+			name:    "route override allows all origins",
+			method:  http.MethodGet,
+			path:    "/public",
+			headers: map[string]string{web.HeaderOrigin: "https://evil.com"},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:      "*",
+				web.HeaderAccessControlAllowCredentials: "",
+				web.HeaderAccessControlExposeHeaders:    "",
+			},
+			wantVary: []string{web.HeaderAcceptEncoding, web.HeaderCookie},
+		},
+		{
+			name:   "route override preflight",
+			method: http.MethodOptions,
+			path:   "/public",
+			headers: map[string]string{
+				web.HeaderOrigin:                      "https://evil.com",
+				web.HeaderAccessControlRequestMethod:  http.MethodPost,
+				web.HeaderAccessControlRequestHeaders: "X-Anything",
+			},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:  "*",
+				web.HeaderAccessControlAllowHeaders: "X-Anything",
+				web.HeaderAccessControlMaxAge:       "",
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:    "route override disables cors",
+			method:  http.MethodGet,
+			path:    "/private",
+			headers: map[string]string{web.HeaderOrigin: "https://example.com"},
+			want:    map[string]string{web.HeaderAccessControlAllowOrigin: ""},
+		},
+		{
+			name:   "route override disables preflight",
+			method: http.MethodOptions,
+			path:   "/private",
+			headers: map[string]string{
+				web.HeaderOrigin:                     "https://example.com",
+				web.HeaderAccessControlRequestMethod: http.MethodPost,
+			},
+			want: map[string]string{
+				web.HeaderAccessControlAllowOrigin:  "",
+				web.HeaderAccessControlAllowMethods: "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if tt.wantStatus != 0 && rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for k, want := range tt.want {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+			if tt.wantVary != nil {
+				if got := varyValues(rec.Header()); strings.Join(got, ",") != strings.Join(tt.wantVary, ",") {
+					t.Errorf("Vary = %v, want %v", got, tt.wantVary)
+				}
+			}
+		})
+	}
+}
+
+func varyValues(header http.Header) []string {
+	var values []string
+	for _, value := range header.Values(web.HeaderVary) {
+		for _, v := range strings.Split(value, ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+	}
+	return values
+}
+
+func TestCORSInvalidPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *CORSPolicy
+	}{
+		{name: "credentials with wildcard origin", policy: &CORSPolicy{AllowOrigins: []string{"*"}, AllowCredentials: true}},
+		{name: "several wildcards", policy: &CORSPolicy{AllowOrigins: []string{"https://*.*.example.com"}}},
+		{name: "invalid pattern", policy: &CORSPolicy{AllowOriginPatterns: []string{"("}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("newCORSMiddleware did not panic")
+				}
+			}()
+			newCORSMiddleware(tt.policy, nil)
+		})
+	}
+
+	t.Run("credentials with wildcard route origin", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("newCORSMiddleware did not panic")
+			}
+		}()
+		newCORSMiddleware(nil, map[string]*CORSPolicy{"/": {AllowOrigins: []string{"*"}, AllowCredentials: true}})
+	})
+}
+
+func TestAddVary(t *testing.T) {
+	header := make(http.Header)
+	header.Set(web.HeaderVary, "accept-encoding, Cookie")
+	addVary(header, web.HeaderOrigin, web.HeaderAcceptEncoding, web.HeaderOrigin)
+	if got := header.Values(web.HeaderVary); strings.Join(got, "|") != "accept-encoding, Cookie|Origin" {
+		t.Errorf("Vary = %q, want %q", got, []string{"accept-encoding, Cookie", "Origin"})
+	}
+
+	header = make(http.Header)
+	header.Set(web.HeaderVary, "*")
+	addVary(header, web.HeaderOrigin)
+	if got := header.Values(web.HeaderVary); len(got) != 1 || got[0] != "*" {
+		t.Errorf("Vary = %q, want %q", got, []string{"*"})
+	}
+}
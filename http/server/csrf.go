@@ -0,0 +1,264 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCSRFCookieName   = "_csrf"
+	hostCSRFCookieName      = "__Host-csrf"
+	defaultCSRFFormField    = "_csrf"
+	defaultCSRFCookieMaxAge = 24 * time.Hour
+	csrfTokenLength         = 32
+)
+
+var (
+	ErrCSRFTokenMissing = errors.New("missing csrf token")
+	ErrCSRFTokenInvalid = errors.New("invalid csrf token")
+	ErrCSRFOrigin       = errors.New("cross-origin request")
+)
+
+// CSRFMode is the way CSRF tokens are stored between requests.
+type CSRFMode int
+
+const (
+	// CSRFModeDoubleSubmit stores the token in a cookie which must be echoed
+	// in a header or form field of unsafe requests.
+	//
+	// The token is not bound to the client, so a host able to set cookies
+	// for the server's domain can plant a token it knows. The default
+	// __Host- cookie cannot be set by other hosts, but cookies named with
+	// CookieName, or scoped with CookieDomain or CookiePath, can be set by
+	// sibling subdomains, which must then be trusted. Use
+	// CSRFModeSynchronizer otherwise.
+	CSRFModeDoubleSubmit CSRFMode = iota
+	// CSRFModeSynchronizer stores the token server-side in a CSRFTokenStore,
+	// usually the session of the client.
+	CSRFModeSynchronizer
+)
+
+// CSRFTokenStore stores the synchronizer token of the client of a request.
+type CSRFTokenStore interface {
+	// GetToken returns the stored token, or an empty string if there is none.
+	GetToken(c echo.Context) (string, error)
+	SetToken(c echo.Context, token string) error
+}
+
+// CSRFOptions configures the CSRF protection of unsafe requests, i.e. all
+// requests except GET, HEAD, OPTIONS and TRACE.
+type CSRFOptions struct {
+	Mode CSRFMode
+	// TokenStore is required with CSRFModeSynchronizer, see
+	// SessionCSRFTokenStore.
+	TokenStore CSRFTokenStore
+
+	// HeaderName defaults to X-CSRF-Token and FormField to _csrf. The token
+	// is looked up in the header first.
+	HeaderName string
+	FormField  string
+
+	// Cookie attributes of CSRFModeDoubleSubmit. The cookie expires after
+	// 24h, and is secure and SameSite=Lax by default. It is readable by
+	// scripts unless CookieHTTPOnly is set. It is named __Host-csrf if it is
+	// secure, host only and has the path /, and _csrf otherwise.
+	CookieName     string
+	CookiePath     string
+	CookieDomain   string
+	CookieMaxAge   time.Duration
+	CookieInsecure bool
+	CookieHTTPOnly bool
+	CookieSameSite http.SameSite
+
+	// TrustedOrigins are origins other than the server's own, e.g.
+	// "https://app.example.com", from which unsafe requests are accepted. A
+	// request with an Origin header, or a Referer header if Origin is
+	// missing, from any other origin is rejected.
+	TrustedOrigins []string
+	// OriginFallback accepts unsafe requests without a token if their Origin
+	// or Referer header is from the server's own or a trusted origin.
+	OriginFallback bool
+
+	// ExemptRoutes are routes, keyed by their path as registered, that are
+	// not protected, e.g. webhooks authenticated by other means.
+	ExemptRoutes []string
+	Skipper      middleware.Skipper
+}
+
+func newCSRFMiddleware(opts CSRFOptions) echo.MiddlewareFunc {
+	if opts.Mode == CSRFModeSynchronizer && opts.TokenStore == nil {
+		panic("server: csrf: synchronizer mode requires a token store")
+	}
+	if opts.HeaderName == "" {
+		opts.HeaderName = web.HeaderXCSRFToken
+	}
+	if opts.FormField == "" {
+		opts.FormField = defaultCSRFFormField
+	}
+	if opts.CookiePath == "" {
+		opts.CookiePath = "/"
+	}
+	if opts.CookieName == "" {
+		// The __Host- prefix keeps other hosts, e.g. sibling subdomains,
+		// from setting the cookie.
+		if !opts.CookieInsecure && opts.CookieDomain == "" && opts.CookiePath == "/" {
+			opts.CookieName = hostCSRFCookieName
+		} else {
+			opts.CookieName = defaultCSRFCookieName
+		}
+	}
+	if opts.CookieMaxAge == 0 {
+		opts.CookieMaxAge = defaultCSRFCookieMaxAge
+	}
+	if opts.CookieSameSite == 0 {
+		opts.CookieSameSite = http.SameSiteLaxMode
+	}
+	if opts.Skipper == nil {
+		opts.Skipper = middleware.DefaultSkipper
+	}
+
+	exemptRoutes := make(map[string]struct{}, len(opts.ExemptRoutes))
+	for _, path := range opts.ExemptRoutes {
+		exemptRoutes[path] = struct{}{}
+	}
+	trustedOrigins := make(map[string]struct{}, len(opts.TrustedOrigins))
+	for _, origin := range opts.TrustedOrigins {
+		trustedOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = struct{}{}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, ok := exemptRoutes[c.Path()]; ok || opts.Skipper(c) {
+				return next(c)
+			}
+
+			token, err := getCSRFToken(c, opts)
+			if err != nil {
+				return err
+			}
+			if token == "" {
+				token, err = newCSRFToken()
+				if err != nil {
+					return err
+				}
+				if err := setCSRFToken(c, opts, token); err != nil {
+					return err
+				}
+			}
+			GetContext(c).CSRFToken = token
+			addVary(c.Response().Header(), web.HeaderCookie)
+
+			if isSafeMethod(c.Request().Method) {
+				return next(c)
+			}
+
+			sameOrigin, ok := checkCSRFOrigin(c, trustedOrigins)
+			if ok && !sameOrigin {
+				return NewHttpErrorWithInternal(http.StatusForbidden, ErrCSRFOrigin.Error(), ErrCSRFOrigin)
+			}
+
+			requestToken := c.Request().Header.Get(opts.HeaderName)
+			if requestToken == "" {
+				requestToken = c.FormValue(opts.FormField)
+			}
+			if requestToken == "" {
+				if opts.OriginFallback && ok && sameOrigin {
+					return next(c)
+				}
+				return NewHttpErrorWithInternal(http.StatusForbidden, ErrCSRFTokenMissing.Error(), ErrCSRFTokenMissing)
+			}
+			if subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) != 1 {
+				return NewHttpErrorWithInternal(http.StatusForbidden, ErrCSRFTokenInvalid.Error(), ErrCSRFTokenInvalid)
+			}
+			return next(c)
+		}
+	}
+}
+
+func getCSRFToken(c echo.Context, opts CSRFOptions) (string, error) {
+	if opts.Mode == CSRFModeSynchronizer {
+		return opts.TokenStore.GetToken(c)
+	}
+
+	cookie, err := c.Cookie(opts.CookieName)
+	if err != nil || !isValidCSRFToken(cookie.Value) {
+		return "", nil
+	}
+	return cookie.Value, nil
+}
+
+func setCSRFToken(c echo.Context, opts CSRFOptions, token string) error {
+	if opts.Mode == CSRFModeSynchronizer {
+		return opts.TokenStore.SetToken(c, token)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     opts.CookieName,
+		Value:    token,
+		Path:     opts.CookiePath,
+		Domain:   opts.CookieDomain,
+		Expires:  time.Now().Add(opts.CookieMaxAge),
+		MaxAge:   int(opts.CookieMaxAge / time.Second),
+		Secure:   !opts.CookieInsecure,
+		HttpOnly: opts.CookieHTTPOnly,
+		SameSite: opts.CookieSameSite,
+	})
+	return nil
+}
+
+// checkCSRFOrigin checks the Origin header of the request, or its Referer
+// header if Origin is missing. ok is false if neither can be checked.
+func checkCSRFOrigin(c echo.Context, trustedOrigins map[string]struct{}) (sameOrigin bool, ok bool) {
+	req := c.Request()
+	origin := req.Header.Get(web.HeaderOrigin)
+	if origin == "" || origin == "null" {
+		referer := req.Referer()
+		if referer == "" {
+			return false, origin == "null"
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false, true
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	origin = strings.ToLower(origin)
+	if _, trusted := trustedOrigins[origin]; trusted {
+		return true, true
+	}
+	sctx := GetContext(c)
+	return origin == strings.ToLower(sctx.ClientScheme+"://"+sctx.ClientHost), true
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, csrfTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating csrf token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func isValidCSRFToken(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == csrfTokenLength
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
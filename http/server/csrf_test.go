@@ -0,0 +1,183 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+func newCSRFTestServer(opts CSRFOptions, sessions *SessionOptions) *Server {
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, CSRF: &opts, Sessions: sessions})
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, GetContext(c).CSRFToken)
+	}
+	e.GET("/", handler)
+	e.POST("/", handler)
+	e.POST("/webhook", handler)
+	return e
+}
+
+// fetchCSRFToken returns the token and cookies of a GET request.
+func fetchCSRFToken(t *testing.T, e *Server) (string, []*http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() == "" {
+		t.Fatalf("GET = %d %q, want a token", rec.Code, rec.Body.String())
+	}
+	return rec.Body.String(), rec.Result().Cookies()
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	e := newCSRFTestServer(CSRFOptions{
+		CookieInsecure: true,
+		TrustedOrigins: []string{"https://app.example.com"},
+		ExemptRoutes:   []string{"/webhook"},
+	}, nil)
+	token, cookies := fetchCSRFToken(t, e)
+	if len(cookies) != 1 || cookies[0].Name != defaultCSRFCookieName || cookies[0].Value != token {
+		t.Fatalf("cookies = %v, want a %s cookie with the token", cookies, defaultCSRFCookieName)
+	}
+	otherToken, _ := newCSRFToken()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		form       string
+		noCookie   bool
+		wantStatus int
+	}{
+		{name: "safe method", method: http.MethodGet, path: "/", wantStatus: http.StatusOK},
+		{name: "header token", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token}, wantStatus: http.StatusOK},
+		{name: "form token", method: http.MethodPost, path: "/", form: defaultCSRFFormField + "=" + url.QueryEscape(token), wantStatus: http.StatusOK},
+		{name: "missing token", method: http.MethodPost, path: "/", wantStatus: http.StatusForbidden},
+		{name: "invalid token", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: otherToken}, wantStatus: http.StatusForbidden},
+		{name: "missing cookie", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token}, noCookie: true, wantStatus: http.StatusForbidden},
+		{name: "same origin", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token, web.HeaderOrigin: "http://example.com"}, wantStatus: http.StatusOK},
+		{name: "trusted origin", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token, web.HeaderOrigin: "https://app.example.com"}, wantStatus: http.StatusOK},
+		{name: "cross origin", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token, web.HeaderOrigin: "https://evil.com"}, wantStatus: http.StatusForbidden},
+		{name: "null origin", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token, web.HeaderOrigin: "null"}, wantStatus: http.StatusForbidden},
+		{name: "cross origin referer", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token, "Referer": "https://evil.com/page"}, wantStatus: http.StatusForbidden},
+		{name: "same origin referer", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderXCSRFToken: token, "Referer": "http://example.com/page"}, wantStatus: http.StatusOK},
+		{name: "same origin without token", method: http.MethodPost, path: "/", header: map[string]string{web.HeaderOrigin: "http://example.com"}, wantStatus: http.StatusForbidden},
+		{name: "exempt route", method: http.MethodPost, path: "/webhook", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		var body io.Reader
+		if tt.form != "" {
+			body = strings.NewReader(tt.form)
+		}
+		req := httptest.NewRequest(tt.method, tt.path, body)
+		if tt.form != "" {
+			req.Header.Set(web.HeaderContentType, web.MIMEApplicationForm)
+		}
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		if !tt.noCookie {
+			req.AddCookie(cookies[0])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestCSRFOriginFallback(t *testing.T) {
+	e := newCSRFTestServer(CSRFOptions{CookieInsecure: true, OriginFallback: true}, nil)
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+	}{
+		{name: "same origin", header: map[string]string{web.HeaderOrigin: "http://example.com"}, wantStatus: http.StatusOK},
+		{name: "same origin referer", header: map[string]string{"Referer": "http://example.com/page"}, wantStatus: http.StatusOK},
+		{name: "cross origin", header: map[string]string{web.HeaderOrigin: "https://evil.com"}, wantStatus: http.StatusForbidden},
+		{name: "no origin", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestCSRFCookieName(t *testing.T) {
+	tests := []struct {
+		name string
+		opts CSRFOptions
+		want string
+	}{
+		{name: "default", want: hostCSRFCookieName},
+		{name: "insecure", opts: CSRFOptions{CookieInsecure: true}, want: defaultCSRFCookieName},
+		{name: "domain", opts: CSRFOptions{CookieDomain: "example.com"}, want: defaultCSRFCookieName},
+		{name: "path", opts: CSRFOptions{CookiePath: "/app"}, want: defaultCSRFCookieName},
+		{name: "custom", opts: CSRFOptions{CookieName: "csrf"}, want: "csrf"},
+	}
+	for _, tt := range tests {
+		e := newCSRFTestServer(tt.opts, nil)
+		_, cookies := fetchCSRFToken(t, e)
+		if len(cookies) != 1 || cookies[0].Name != tt.want {
+			t.Errorf("%s: cookies = %v, want a %s cookie", tt.name, cookies, tt.want)
+		}
+	}
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	store, err := NewCookieSessionStore([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newCSRFTestServer(
+		CSRFOptions{Mode: CSRFModeSynchronizer, TokenStore: SessionCSRFTokenStore()},
+		&SessionOptions{Store: store, CookieInsecure: true},
+	)
+	token, cookies := fetchCSRFToken(t, e)
+	if len(cookies) != 1 || cookies[0].Name != defaultSessionCookieName {
+		t.Fatalf("cookies = %v, want only the session cookie", cookies)
+	}
+	otherToken, _ := newCSRFToken()
+
+	tests := []struct {
+		name       string
+		token      string
+		noSession  bool
+		wantStatus int
+	}{
+		{name: "valid token", token: token, wantStatus: http.StatusOK},
+		{name: "missing token", wantStatus: http.StatusForbidden},
+		{name: "invalid token", token: otherToken, wantStatus: http.StatusForbidden},
+		{name: "missing session", token: token, noSession: true, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tt.token != "" {
+			req.Header.Set(web.HeaderXCSRFToken, tt.token)
+		}
+		if !tt.noSession {
+			req.AddCookie(cookies[0])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/labstack/echo/v4"
+)
+
+// NoContent can be used as the response type of typed handlers that respond
+// with 204 No Content.
+type NoContent struct{}
+
+// StatusCoder can be implemented by response types of typed handlers to
+// choose the response status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// ErrorMapper maps errors returned by typed handlers, typically domain errors,
+// to errors understood by the error handler such as *echo.HTTPError or
+// *Problem. It should return err unchanged if it does not know it.
+type ErrorMapper func(err error) error
+
+type HandleOptions struct {
+	// Status is the status code of successful responses. It defaults to 200,
+	// or 204 for NoContent responses, and is overridden by responses
+	// implementing StatusCoder.
+	Status int
+	// ErrorMapper is applied to handler errors before the server ErrorMapper.
+	ErrorMapper ErrorMapper
+}
+
+// Handle adapts a typed handler to an echo.HandlerFunc. The request is bound
+// from the body and then from fields tagged with query, header and param, so
+// path params take precedence, or with the server Binder if it is not the
+// default one, and validated with the server validator before calling fn.
+// The response is rendered as JSON.
+func Handle[Req, Resp any](fn func(ctx *Context, req Req) (Resp, error)) echo.HandlerFunc {
+	return HandleWithOptions(fn, HandleOptions{})
+}
+
+func HandleWithOptions[Req, Resp any](fn func(ctx *Context, req Req) (Resp, error), opts HandleOptions) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sctx := GetContext(c)
+
+		var req Req
+		if err := bindRequest(c, &req); err != nil {
+			return err
+		}
+		if err := Validate(c, &req); err != nil {
+			return err
+		}
+
+		resp, err := fn(sctx, req)
+		if err != nil {
+			if opts.ErrorMapper != nil {
+				err = opts.ErrorMapper(err)
+			}
+			if sctx.errorMapper != nil {
+				err = sctx.errorMapper(err)
+			}
+			return err
+		}
+
+		status := opts.Status
+		if sc, ok := any(resp).(StatusCoder); ok {
+			status = sc.StatusCode()
+		}
+		if _, ok := any(resp).(NoContent); ok {
+			if status == 0 {
+				status = http.StatusNoContent
+			}
+			return c.NoContent(status)
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		return c.JSON(status, resp)
+	}
+}
+
+// bindRequest binds the request into v with the binder of the server. The
+// default binder binds the body, query params, headers and path params of
+// the request, in that order, whatever the request method. Only the body is
+// bound into non struct types.
+func bindRequest(c echo.Context, v any) error {
+	binder, ok := c.Echo().Binder.(*echo.DefaultBinder)
+	if !ok {
+		return c.Echo().Binder.Bind(v, c)
+	}
+	if err := binder.BindBody(c, v); err != nil {
+		return err
+	}
+	if !isStruct(v) {
+		return nil
+	}
+	if err := binder.BindQueryParams(c, v); err != nil {
+		return err
+	}
+	if err := binder.BindHeaders(c, v); err != nil {
+		return err
+	}
+	return binder.BindPathParams(c, v)
+}
+
+func isStruct(v any) bool {
+	typ := derefType(reflect.TypeOf(v))
+	return typ != nil && typ.Kind() == reflect.Struct
+}
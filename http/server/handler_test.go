@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+type handleRequest struct {
+	ID   int    `param:"id" validate:"required"`
+	Name string `json:"name" validate:"required"`
+}
+
+type handleResponse struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (handleResponse) StatusCode() int {
+	return http.StatusCreated
+}
+
+var errHandleConflict = errors.New("conflict")
+
+func newHandleTestServer() *Server {
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, Validator: validator.New()})
+	e.POST("/items/:id", HandleWithOptions(func(ctx *Context, req handleRequest) (handleResponse, error) {
+		if req.Name == "taken" {
+			return handleResponse{}, errHandleConflict
+		}
+		return handleResponse{ID: req.ID, Name: req.Name}, nil
+	}, HandleOptions{ErrorMapper: func(err error) error {
+		if errors.Is(err, errHandleConflict) {
+			return NewProblem(http.StatusConflict, err.Error())
+		}
+		return err
+	}}))
+	e.DELETE("/items/:id", Handle(func(ctx *Context, req handleRequest) (NoContent, error) {
+		return NoContent{}, nil
+	}))
+	return e
+}
+
+func TestHandle(t *testing.T) {
+	e := newHandleTestServer()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "success", method: http.MethodPost, target: "/items/7", body: `{"name":"a"}`, wantStatus: http.StatusCreated, wantBody: `{"id":7,"name":"a"}`},
+		{name: "no content", method: http.MethodDelete, target: "/items/7", body: `{"name":"a"}`, wantStatus: http.StatusNoContent},
+		{name: "bind error", method: http.MethodPost, target: "/items/7", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "bind type error", method: http.MethodPost, target: "/items/x", body: `{"name":"a"}`, wantStatus: http.StatusBadRequest},
+		{name: "validation error", method: http.MethodPost, target: "/items/7", body: `{}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "mapped error", method: http.MethodPost, target: "/items/7", body: `{"name":"taken"}`, wantStatus: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body.String())
+			continue
+		}
+		if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+			t.Errorf("%s: body = %s, want %s", tt.name, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+type handleTestBinder struct{}
+
+func (handleTestBinder) Bind(i any, c echo.Context) error {
+	req := i.(*handleRequest)
+	req.ID = 1
+	req.Name = c.Request().Header.Get("X-Name")
+	return nil
+}
+
+func TestHandleServerBinder(t *testing.T) {
+	e := newHandleTestServer()
+	e.Binder = handleTestBinder{}
+
+	req := httptest.NewRequest(http.MethodPost, "/items/7", strings.NewReader(`{"name":"a"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-Name", "b")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if want := `{"id":1,"name":"b"}`; rec.Code != http.StatusCreated || strings.TrimSpace(rec.Body.String()) != want {
+		t.Errorf("response = %d %s, want %d %s", rec.Code, rec.Body.String(), http.StatusCreated, want)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultHealthCheckTimeout = 5 * time.Second
+
+	HealthStatusOK       = "ok"
+	HealthStatusDegraded = "degraded"
+	HealthStatusFail     = "fail"
+
+	shutdownCheckName = "shutdown"
+)
+
+// HealthCheckKind selects the endpoints a health check is part of. All checks
+// are part of /healthz.
+type HealthCheckKind int
+
+const (
+	// HealthCheckReadiness checks are part of /readyz. A failing critical
+	// readiness check means the server should not receive traffic.
+	HealthCheckReadiness HealthCheckKind = iota
+	// HealthCheckLiveness checks are part of /livez. A failing critical
+	// liveness check means the process should be restarted.
+	HealthCheckLiveness
+	// HealthCheckBoth checks are part of both /readyz and /livez.
+	HealthCheckBoth
+)
+
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+	Kind  HealthCheckKind
+	// Timeout bounds a single run of the check. It defaults to 5s.
+	Timeout time.Duration
+	// Critical checks fail the aggregate status, other failing checks only
+	// degrade it.
+	Critical bool
+	// CacheTTL caches results of the check, protecting dependencies from
+	// frequent probes.
+	CacheTTL time.Duration
+}
+
+// HealthCheckResult is the result of a single health check.
+type HealthCheckResult struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+	Cached   bool   `json:"cached,omitempty"`
+}
+
+// HealthReport is the aggregate result of the health checks of an endpoint.
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+// Health is a registry of named health checks served at /healthz, /readyz
+// and /livez by servers created with it in Options.Health.
+type Health struct {
+	mu     sync.RWMutex
+	checks []*healthCheckEntry
+}
+
+type healthCheckEntry struct {
+	check HealthCheck
+
+	mu        sync.Mutex
+	result    HealthCheckResult
+	checkedAt time.Time
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// Register adds a health check. Check names must be unique.
+func (h *Health) Register(check HealthCheck) {
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, entry := range h.checks {
+		if entry.check.Name == check.Name {
+			panic("health check " + check.Name + " already registered")
+		}
+	}
+	h.checks = append(h.checks, &healthCheckEntry{check: check})
+}
+
+// Run runs the health checks selected by include concurrently and returns the
+// aggregate report.
+func (h *Health) Run(ctx context.Context, include func(kind HealthCheckKind) bool) HealthReport {
+	h.mu.RLock()
+	var entries []*healthCheckEntry
+	for _, entry := range h.checks {
+		if include == nil || include(entry.check.Kind) {
+			entries = append(entries, entry)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]HealthCheckResult, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = entry.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: HealthStatusOK, Checks: make(map[string]HealthCheckResult, len(entries))}
+	for i, entry := range entries {
+		report.Checks[entry.check.Name] = results[i]
+		report.addResult(results[i])
+	}
+	return report
+}
+
+func (r *HealthReport) addResult(result HealthCheckResult) {
+	if result.Status == HealthStatusOK || r.Status == HealthStatusFail {
+		return
+	}
+	if result.Critical {
+		r.Status = HealthStatusFail
+	} else {
+		r.Status = HealthStatusDegraded
+	}
+}
+
+func (e *healthCheckEntry) run(ctx context.Context) HealthCheckResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.check.CacheTTL > 0 && !e.checkedAt.IsZero() && time.Since(e.checkedAt) < e.check.CacheTTL {
+		result := e.result
+		result.Cached = true
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		if e.check.Check == nil {
+			errCh <- nil
+			return
+		}
+		errCh <- e.check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := HealthCheckResult{Status: HealthStatusOK, Critical: e.check.Critical, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = HealthStatusFail
+		result.Error = err.Error()
+	}
+	e.result = result
+	e.checkedAt = time.Now()
+	return result
+}
+
+// HealthHandler serves the aggregate report of all health checks.
+func HealthHandler(h *Health) echo.HandlerFunc {
+	return healthHandler(h, nil, false)
+}
+
+// ReadinessHandler serves the report of the readiness checks. It fails while
+// the server is shutting down.
+func ReadinessHandler(h *Health) echo.HandlerFunc {
+	return healthHandler(h, func(kind HealthCheckKind) bool {
+		return kind == HealthCheckReadiness || kind == HealthCheckBoth
+	}, true)
+}
+
+// LivenessHandler serves the report of the liveness checks.
+func LivenessHandler(h *Health) echo.HandlerFunc {
+	return healthHandler(h, func(kind HealthCheckKind) bool {
+		return kind == HealthCheckLiveness || kind == HealthCheckBoth
+	}, false)
+}
+
+func healthHandler(h *Health, include func(kind HealthCheckKind) bool, checkShutdown bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report := h.Run(c.Request().Context(), include)
+		if checkShutdown {
+			result := HealthCheckResult{Status: HealthStatusOK, Critical: true, Duration: time.Duration(0).String()}
+			if server := GetContext(c).server; server != nil && IsShuttingDown(server) {
+				result.Status = HealthStatusFail
+				result.Error = "server is shutting down"
+			}
+			report.Checks[shutdownCheckName] = result
+			report.addResult(result)
+		}
+
+		status := http.StatusOK
+		if report.Status == HealthStatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		return c.JSON(status, report)
+	}
+}
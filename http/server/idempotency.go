@@ -0,0 +1,430 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultIdempotencyTTL             = 24 * time.Hour
+	defaultIdempotencyInFlightTTL     = time.Minute
+	defaultIdempotencyMaxResponseSize = 1 << 20
+	maxIdempotencyKeyLength           = 255
+	idempotencyStoreSweep             = time.Minute
+)
+
+var (
+	ErrIdempotencyKeyMissing  = errors.New("missing idempotency key")
+	ErrIdempotencyKeyInvalid  = errors.New("invalid idempotency key")
+	ErrIdempotencyInFlight    = errors.New("a request with the same idempotency key is in progress")
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key reused with a different request body")
+)
+
+// IdempotencyRecord is the stored state of an idempotency key.
+type IdempotencyRecord struct {
+	// RequestHash is the hex encoded SHA-256 hash of the request body.
+	RequestHash string
+	// Completed is false while the first request is in flight.
+	Completed bool
+	Status    int
+	Header    http.Header
+	Body      []byte
+}
+
+// IdempotencyStore stores the responses of requests by idempotency key.
+type IdempotencyStore interface {
+	// Start atomically creates an in-flight record for key expiring after
+	// ttl, unless there is one already. It returns the existing record, or
+	// nil if the record was created.
+	Start(ctx context.Context, key string, requestHash string, ttl time.Duration) (*IdempotencyRecord, error)
+	// Complete stores the response of the in-flight request of key.
+	Complete(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error
+	// Delete deletes the record of key so that the request can be retried.
+	Delete(ctx context.Context, key string) error
+}
+
+// IdempotencyOptions configures the handling of the Idempotency-Key header.
+type IdempotencyOptions struct {
+	// Store defaults to a new MemoryIdempotencyStore.
+	Store IdempotencyStore
+	// Methods defaults to POST.
+	Methods []string
+	// Required rejects requests without an Idempotency-Key header with a 400
+	// error.
+	Required bool
+	// TTL is how long responses are replayed, defaulting to 24h. InFlightTTL
+	// bounds how long a key stays locked if the server dies while handling
+	// the first request, defaulting to 1m.
+	TTL         time.Duration
+	InFlightTTL time.Duration
+	// MaxResponseSize is the size of the largest response body stored,
+	// defaulting to 1MB. Larger responses are not replayed.
+	MaxResponseSize int
+	Skipper         middleware.Skipper
+}
+
+// newIdempotencyMiddleware returns a middleware storing the first response
+// for each idempotency key, route and principal, and replaying it for
+// retries. Retries fail with a 409 error while the first request is in
+// flight, including while the handler of a request that timed out still runs,
+// and with a 422 error if their body differs. Failed requests, i.e.
+// those returning an error or a 5xx status, are not stored so that they can
+// be retried.
+func newIdempotencyMiddleware(opts IdempotencyOptions) echo.MiddlewareFunc {
+	if opts.Store == nil {
+		opts.Store = NewMemoryIdempotencyStore()
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{http.MethodPost}
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultIdempotencyTTL
+	}
+	if opts.InFlightTTL <= 0 {
+		opts.InFlightTTL = defaultIdempotencyInFlightTTL
+	}
+	if opts.MaxResponseSize <= 0 {
+		opts.MaxResponseSize = defaultIdempotencyMaxResponseSize
+	}
+	if opts.Skipper == nil {
+		opts.Skipper = middleware.DefaultSkipper
+	}
+	methods := make(map[string]struct{}, len(opts.Methods))
+	for _, method := range opts.Methods {
+		methods[method] = struct{}{}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if _, ok := methods[req.Method]; !ok || opts.Skipper(c) {
+				return next(c)
+			}
+
+			idempotencyKey := req.Header.Get(web.HeaderIdempotencyKey)
+			if idempotencyKey == "" {
+				if opts.Required {
+					return NewHttpErrorWithInternal(http.StatusBadRequest, ErrIdempotencyKeyMissing.Error(), ErrIdempotencyKeyMissing)
+				}
+				return next(c)
+			}
+			if len(idempotencyKey) > maxIdempotencyKeyLength {
+				return NewHttpErrorWithInternal(http.StatusBadRequest, ErrIdempotencyKeyInvalid.Error(), ErrIdempotencyKeyInvalid)
+			}
+
+			requestHash, err := hashRequestBody(req)
+			if err != nil {
+				return err
+			}
+			key := idempotencyStoreKey(c, idempotencyKey)
+			ctx := req.Context()
+
+			record, err := opts.Store.Start(ctx, key, requestHash, opts.InFlightTTL)
+			if err != nil {
+				return errors.Wrap(err, "idempotency store")
+			}
+			if record != nil {
+				switch {
+				case record.RequestHash != requestHash:
+					return NewHttpErrorWithInternal(http.StatusUnprocessableEntity, ErrIdempotencyKeyMismatch.Error(), ErrIdempotencyKeyMismatch)
+				case !record.Completed:
+					c.Response().Header().Set(web.HeaderRetryAfter, "1")
+					return NewHttpErrorWithInternal(http.StatusConflict, ErrIdempotencyInFlight.Error(), ErrIdempotencyInFlight)
+				default:
+					return replayIdempotentResponse(c, record)
+				}
+			}
+
+			return handleIdempotentRequest(c, next, opts, key, requestHash)
+		}
+	}
+}
+
+func handleIdempotentRequest(c echo.Context, next echo.HandlerFunc, opts IdempotencyOptions, key, requestHash string) (err error) {
+	// The store context outlives the request so that records are not left
+	// in flight when the client goes away.
+	storeCtx := context.WithoutCancel(c.Request().Context())
+	sctx := GetContext(c)
+	inFlight := &idempotentRequest{store: opts.Store, ctx: storeCtx, key: key, logger: sctx.ServerLogger, refs: 1}
+	sctx.idempotentRequest = inFlight
+	completed, handlerDone := false, true
+	defer func() {
+		if !completed && handlerDone {
+			if deleteErr := opts.Store.Delete(storeCtx, key); deleteErr != nil && err == nil {
+				err = errors.Wrap(deleteErr, "idempotency store")
+			}
+		}
+	}()
+
+	res := c.Response()
+	headerBefore := res.Header().Clone()
+	recorder := &idempotencyRecorder{ResponseWriter: res.Writer, maxSize: opts.MaxResponseSize}
+	res.Writer = recorder
+	defer func() {
+		res.Writer = recorder.ResponseWriter
+	}()
+
+	err = next(c)
+	if handlerDone = inFlight.release(); !handlerDone {
+		// The request timed out and its handler is still running. The record
+		// stays in flight until the handler returns, so that retries do not
+		// run concurrently with it.
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !res.Committed || res.Status >= http.StatusInternalServerError || recorder.truncated {
+		return nil
+	}
+
+	record := IdempotencyRecord{
+		RequestHash: requestHash,
+		Completed:   true,
+		Status:      res.Status,
+		Header:      handlerHeader(headerBefore, recorder.header),
+		Body:        recorder.body.Bytes(),
+	}
+	if err := opts.Store.Complete(storeCtx, key, record, opts.TTL); err != nil {
+		return errors.Wrap(err, "idempotency store")
+	}
+	completed = true
+	return nil
+}
+
+// idempotentRequest is the in-flight request of an idempotency key. The
+// timeout middleware runs handlers in their own goroutine, which outlives the
+// request if it times out, so the request is reference counted and its record
+// deleted once both the request and its handler are done.
+type idempotentRequest struct {
+	store  IdempotencyStore
+	ctx    context.Context
+	key    string
+	logger *zerolog.Logger
+
+	mu   sync.Mutex
+	refs int
+}
+
+// acquire adds a reference to r. It returns false if r was already released,
+// i.e. the request timed out before its handler started.
+func (r *idempotentRequest) acquire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.refs == 0 {
+		return false
+	}
+	r.refs++
+	return true
+}
+
+// release releases a reference to r and reports whether it was the last one.
+func (r *idempotentRequest) release() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refs--
+	return r.refs == 0
+}
+
+// newIdempotencyHandlerMiddleware returns a middleware holding a reference to
+// the in-flight idempotent request while the handler runs, within the
+// timeout.
+func newIdempotencyHandlerMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := GetContext(c).idempotentRequest
+			if r == nil {
+				return next(c)
+			}
+			if !r.acquire() {
+				return c.Request().Context().Err()
+			}
+			defer func() {
+				if r.release() {
+					// The request timed out, so the response is lost and the
+					// request can be retried.
+					if err := r.store.Delete(r.ctx, r.key); err != nil {
+						r.logger.Error().Err(err).Msg("deleting idempotency record")
+					}
+				}
+			}()
+			return next(c)
+		}
+	}
+}
+
+// handlerHeader returns the headers set by the handler, skipping those set
+// by outer middleware, cookies and Content-Length, which depends on the
+// encoding of the replayed response.
+func handlerHeader(before, after http.Header) http.Header {
+	header := make(http.Header)
+	for k, values := range after {
+		if k == web.HeaderSetCookie || k == web.HeaderContentLength {
+			continue
+		}
+		if beforeValues, ok := before[k]; ok && equalStrings(beforeValues, values) {
+			continue
+		}
+		header[k] = append([]string(nil), values...)
+	}
+	return header
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func replayIdempotentResponse(c echo.Context, record *IdempotencyRecord) error {
+	header := c.Response().Header()
+	for k, values := range record.Header {
+		header[k] = append([]string(nil), values...)
+	}
+	header.Set(web.HeaderIdempotentReplayed, "true")
+	// The compression middleware re-encodes the body for the client.
+	if !isCompressing(c.Response().Writer) {
+		header.Set(web.HeaderContentLength, strconv.Itoa(len(record.Body)))
+	}
+	c.Response().WriteHeader(record.Status)
+	_, err := c.Response().Write(record.Body)
+	return err
+}
+
+func hashRequestBody(req *http.Request) (string, error) {
+	h := sha256.New()
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// idempotencyStoreKey scopes idempotencyKey to the route and principal of
+// the request, so that clients cannot replay responses of other clients.
+func idempotencyStoreKey(c echo.Context, idempotencyKey string) string {
+	h := sha256.New()
+	h.Write([]byte(c.Request().Method + " " + c.Path() + "\n"))
+	if principal := GetContext(c).Principal; principal != nil {
+		h.Write([]byte(principal.Method + ":" + principal.Subject))
+	}
+	h.Write([]byte("\n" + idempotencyKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyRecorder records the response of the handler. The header is
+// recorded when it is written, before outer middleware like compression
+// changes representation headers such as Content-Encoding and ETag.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	header    http.Header
+	body      bytes.Buffer
+	maxSize   int
+	truncated bool
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.header == nil && status >= http.StatusOK {
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if !r.truncated {
+		if r.body.Len()+len(b) > r.maxSize {
+			r.truncated = true
+			r.body.Reset()
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *idempotencyRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Records are not
+// shared between processes.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]memoryIdempotencyRecord
+	lastSweep time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	record    IdempotencyRecord
+	expiresAt time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]memoryIdempotencyRecord)}
+}
+
+func (s *MemoryIdempotencyStore) Start(_ context.Context, key string, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= idempotencyStoreSweep {
+		for k, r := range s.records {
+			if !now.Before(r.expiresAt) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if r, ok := s.records[key]; ok && now.Before(r.expiresAt) {
+		record := r.record
+		return &record, nil
+	}
+	s.records[key] = memoryIdempotencyRecord{record: IdempotencyRecord{RequestHash: requestHash}, expiresAt: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(_ context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = memoryIdempotencyRecord{record: record, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
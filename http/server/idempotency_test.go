@@ -0,0 +1,267 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+func newIdempotencyTestServer(opts Options) *Server {
+	opts.LoggerWriter = io.Discard
+	if opts.Idempotency == nil {
+		opts.Idempotency = &IdempotencyOptions{Store: NewMemoryIdempotencyStore()}
+	}
+	opts.Authenticators = append(opts.Authenticators, AuthenticatorFunc(func(c echo.Context) (*Principal, error) {
+		if user := c.Request().Header.Get("X-User"); user != "" {
+			return &Principal{Method: "test", Subject: user}, nil
+		}
+		return nil, nil
+	}))
+	return NewWithOptions(opts)
+}
+
+func serveIdempotent(e *Server, path, key, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(web.HeaderIdempotencyKey, key)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	e := newIdempotencyTestServer(Options{})
+	var calls atomic.Int32
+	e.POST("/orders", func(c echo.Context) error {
+		n := calls.Add(1)
+		c.Response().Header().Set("X-Order", strconv.Itoa(int(n)))
+		return c.String(http.StatusCreated, "order "+strconv.Itoa(int(n)))
+	})
+
+	first := serveIdempotent(e, "/orders", "k1", "{}", nil)
+	if first.Code != http.StatusCreated || first.Body.String() != "order 1" {
+		t.Fatalf("first response = %d %q, want 201 \"order 1\"", first.Code, first.Body.String())
+	}
+	if first.Header().Get(web.HeaderIdempotentReplayed) != "" {
+		t.Errorf("first response has %s header", web.HeaderIdempotentReplayed)
+	}
+
+	replay := serveIdempotent(e, "/orders", "k1", "{}", nil)
+	if replay.Code != http.StatusCreated || replay.Body.String() != "order 1" {
+		t.Fatalf("replayed response = %d %q, want 201 \"order 1\"", replay.Code, replay.Body.String())
+	}
+	if got := replay.Header().Get(web.HeaderIdempotentReplayed); got != "true" {
+		t.Errorf("%s = %q, want true", web.HeaderIdempotentReplayed, got)
+	}
+	if got := replay.Header().Get("X-Order"); got != "1" {
+		t.Errorf("replayed X-Order = %q, want 1", got)
+	}
+
+	if rec := serveIdempotent(e, "/orders", "k2", "{}", nil); rec.Body.String() != "order 2" {
+		t.Errorf("other key response = %q, want \"order 2\"", rec.Body.String())
+	}
+	if rec := serveIdempotent(e, "/orders", "", "{}", nil); rec.Body.String() != "order 3" {
+		t.Errorf("response without key = %q, want \"order 3\"", rec.Body.String())
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("handler calls = %d, want 3", n)
+	}
+}
+
+func TestIdempotencyKeyMismatch(t *testing.T) {
+	e := newIdempotencyTestServer(Options{})
+	var calls atomic.Int32
+	e.POST("/orders", func(c echo.Context) error {
+		calls.Add(1)
+		return c.NoContent(http.StatusCreated)
+	})
+
+	serveIdempotent(e, "/orders", "k", `{"amount":1}`, nil)
+	rec := serveIdempotent(e, "/orders", "k", `{"amount":2}`, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler calls = %d, want 1", n)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	e := newIdempotencyTestServer(Options{})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	e.POST("/orders", func(c echo.Context) error {
+		close(started)
+		<-release
+		return c.NoContent(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serveIdempotent(e, "/orders", "k", "{}", nil)
+	}()
+	<-started
+
+	rec := serveIdempotent(e, "/orders", "k", "{}", nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if got := rec.Header().Get(web.HeaderRetryAfter); got != "1" {
+		t.Errorf("%s = %q, want 1", web.HeaderRetryAfter, got)
+	}
+	// The body is checked before the in-flight state.
+	if rec := serveIdempotent(e, "/orders", "k", "[]", nil); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status with a different body = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Errorf("first status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyFailuresNotStored(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(c echo.Context, n int32) error
+	}{
+		{
+			name: "5xx status",
+			handler: func(c echo.Context, n int32) error {
+				if n == 1 {
+					return c.String(http.StatusServiceUnavailable, "unavailable")
+				}
+				return c.String(http.StatusCreated, "created")
+			},
+		},
+		{
+			name: "error",
+			handler: func(c echo.Context, n int32) error {
+				if n == 1 {
+					return echo.NewHTTPError(http.StatusBadGateway)
+				}
+				return c.String(http.StatusCreated, "created")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newIdempotencyTestServer(Options{})
+			var calls atomic.Int32
+			e.POST("/orders", func(c echo.Context) error {
+				return tt.handler(c, calls.Add(1))
+			})
+
+			if rec := serveIdempotent(e, "/orders", "k", "{}", nil); rec.Code < http.StatusInternalServerError {
+				t.Fatalf("first status = %d, want 5xx", rec.Code)
+			}
+			rec := serveIdempotent(e, "/orders", "k", "{}", nil)
+			if rec.Code != http.StatusCreated || rec.Header().Get(web.HeaderIdempotentReplayed) != "" {
+				t.Errorf("retry = %d %q, want a new 201 response", rec.Code, rec.Body.String())
+			}
+			if rec := serveIdempotent(e, "/orders", "k", "{}", nil); rec.Header().Get(web.HeaderIdempotentReplayed) != "true" {
+				t.Errorf("second retry was not replayed")
+			}
+			if n := calls.Load(); n != 2 {
+				t.Errorf("handler calls = %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	e := newIdempotencyTestServer(Options{})
+	var calls atomic.Int32
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusCreated, strconv.Itoa(int(calls.Add(1))))
+	}
+	e.POST("/orders", handler)
+	e.POST("/payments", handler)
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		want   string
+	}{
+		{name: "alice", path: "/orders", header: map[string]string{"X-User": "alice"}, want: "1"},
+		{name: "alice retry", path: "/orders", header: map[string]string{"X-User": "alice"}, want: "1"},
+		{name: "bob", path: "/orders", header: map[string]string{"X-User": "bob"}, want: "2"},
+		{name: "anonymous", path: "/orders", want: "3"},
+		{name: "other route", path: "/payments", header: map[string]string{"X-User": "alice"}, want: "4"},
+		{name: "bob retry", path: "/orders", header: map[string]string{"X-User": "bob"}, want: "2"},
+	}
+	for _, tt := range tests {
+		rec := serveIdempotent(e, tt.path, "k", "{}", tt.header)
+		if rec.Body.String() != tt.want {
+			t.Errorf("%s: response = %q, want %q", tt.name, rec.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestIdempotencyKeyValidation(t *testing.T) {
+	e := newIdempotencyTestServer(Options{Idempotency: &IdempotencyOptions{Required: true}})
+	e.POST("/orders", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	})
+
+	if rec := serveIdempotent(e, "/orders", "", "{}", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("status without key = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serveIdempotent(e, "/orders", strings.Repeat("k", maxIdempotencyKeyLength+1), "{}", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("status with a long key = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serveIdempotent(e, "/orders", "k", "{}", nil); rec.Code != http.StatusCreated {
+		t.Errorf("status with key = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyTimeout(t *testing.T) {
+	e := newIdempotencyTestServer(Options{RouteTimeouts: map[string]time.Duration{"/orders": 20 * time.Millisecond}})
+	var calls atomic.Int32
+	release := make(chan struct{})
+	e.POST("/orders", func(c echo.Context) error {
+		if calls.Add(1) == 1 {
+			<-release
+			// The response of a timed out request is not sent.
+			return nil
+		}
+		return c.NoContent(http.StatusCreated)
+	})
+
+	if rec := serveIdempotent(e, "/orders", "k", "{}", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	// The handler of the first request still runs.
+	if rec := serveIdempotent(e, "/orders", "k", "{}", nil); rec.Code != http.StatusConflict {
+		t.Errorf("retry status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		rec := serveIdempotent(e, "/orders", "k", "{}", nil)
+		if rec.Code == http.StatusCreated {
+			break
+		}
+		if rec.Code != http.StatusConflict || time.Now().After(deadline) {
+			t.Fatalf("retry status after the handler returned = %d, want %d", rec.Code, http.StatusCreated)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler calls = %d, want 2", n)
+	}
+}
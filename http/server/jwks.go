@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultJWKSCacheTTL        = time.Hour
+	defaultJWKSMinRefreshDelay = time.Minute
+	defaultJWKSTimeout         = 10 * time.Second
+	maxJWKSSize                = 1 << 20
+)
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	K         string `json:"k"`
+}
+
+// ParseJWKS parses an RFC 7517 JSON Web Key Set. RSA, EC and symmetric keys
+// are supported, other keys and encryption keys are skipped.
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "parsing jwks")
+	}
+
+	keys := make([]JWTKey, 0, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key any
+		var err error
+		switch k.KeyType {
+		case "RSA":
+			key, err = parseRSAJWK(k)
+		case "EC":
+			key, err = parseECJWK(k)
+		case "oct":
+			key, err = base64.RawURLEncoding.DecodeString(k.K)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing jwks key %d", i)
+		}
+		keys = append(keys, JWTKey{ID: k.KeyID, Algorithm: k.Algorithm, Key: key})
+	}
+	return keys, nil
+}
+
+func parseRSAJWK(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid rsa exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+func parseECJWK(k jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Curve {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("point is not on curve")
+	}
+	return pub, nil
+}
+
+// NewJWKSFileKeySet returns a key set of the keys in the JWKS file at path.
+func NewJWKSFileKeySet(path string) (JWTKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return staticJWTKeySet(keys), nil
+}
+
+// JWKSURLOptions configures a key set fetched from a URL.
+type JWKSURLOptions struct {
+	// HttpClient defaults to a client with a 10s timeout.
+	HttpClient *http.Client
+	// CacheTTL is how long keys are cached, defaulting to 1h.
+	CacheTTL time.Duration
+	// MinRefreshDelay is the minimum time between fetches triggered by
+	// unknown key ids, defaulting to 1m.
+	MinRefreshDelay time.Duration
+}
+
+type jwksURLKeySet struct {
+	url  string
+	opts JWKSURLOptions
+
+	mu          sync.Mutex
+	keys        []JWTKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetchErr    error
+	// refreshing is closed once the fetch in progress, if any, is done.
+	refreshing chan struct{}
+}
+
+// NewJWKSURLKeySet returns a key set fetched from url, e.g. the jwks_uri of an
+// OpenID provider. Keys are fetched lazily, cached for CacheTTL and
+// refetched early when a token has an unknown key id, for key rotation. If a
+// refetch fails, the cached keys keep being used. Concurrent refetches are
+// deduplicated and cached keys are served while a refetch is in progress.
+func NewJWKSURLKeySet(url string, opts JWKSURLOptions) JWTKeySet {
+	if opts.HttpClient == nil {
+		opts.HttpClient = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultJWKSCacheTTL
+	}
+	if opts.MinRefreshDelay <= 0 {
+		opts.MinRefreshDelay = defaultJWKSMinRefreshDelay
+	}
+	return &jwksURLKeySet{url: url, opts: opts}
+}
+
+func (s *jwksURLKeySet) Keys(ctx context.Context, kid string) ([]JWTKey, error) {
+	s.mu.Lock()
+	now := time.Now()
+	stale := s.fetchedAt.IsZero() || now.Sub(s.fetchedAt) >= s.opts.CacheTTL
+	keys := filterJWTKeys(s.keys, kid)
+	if !stale && len(keys) > 0 {
+		s.mu.Unlock()
+		return keys, nil
+	}
+
+	refreshing := s.refreshing
+	if refreshing == nil {
+		if !s.attemptedAt.IsZero() && now.Sub(s.attemptedAt) < s.opts.MinRefreshDelay {
+			err := s.fetchErr
+			s.mu.Unlock()
+			return keys, err
+		}
+		s.attemptedAt = now
+		refreshing = make(chan struct{})
+		s.refreshing = refreshing
+		// The fetch is shared by concurrent callers, so it is not canceled
+		// with the context of the caller starting it.
+		go s.refresh(context.WithoutCancel(ctx), refreshing)
+	}
+	s.mu.Unlock()
+	if len(keys) > 0 {
+		return keys, nil
+	}
+
+	select {
+	case <-refreshing:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return filterJWTKeys(s.keys, kid), s.fetchErr
+}
+
+// refresh fetches the keys and closes done. If the fetch fails, the cached
+// keys are kept.
+func (s *jwksURLKeySet) refresh(ctx context.Context, done chan struct{}) {
+	fetched, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(done)
+	s.refreshing = nil
+	if err != nil {
+		if s.fetchedAt.IsZero() {
+			s.fetchErr = err
+		}
+		return
+	}
+	s.fetchErr = nil
+	s.keys = fetched
+	s.fetchedAt = time.Now()
+}
+
+func (s *jwksURLKeySet) fetch(ctx context.Context) ([]JWTKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.opts.HttpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching jwks")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching jwks")
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type jwksTestServer struct {
+	*httptest.Server
+	fetches atomic.Int32
+	mu      sync.Mutex
+	kids    []string
+	block   chan struct{}
+}
+
+func newJWKSTestServer(t *testing.T, kids ...string) *jwksTestServer {
+	s := &jwksTestServer{kids: kids}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		block, kids := s.block, s.kids
+		s.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+
+		key := base64.RawURLEncoding.EncodeToString([]byte("secret"))
+		fmt.Fprint(w, `{"keys":[`)
+		for i, kid := range kids {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"kty":"oct","kid":%q,"k":%q}`, kid, key)
+		}
+		fmt.Fprint(w, `]}`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksTestServer) set(kids []string, block chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kids = kids
+	s.block = block
+}
+
+func TestJWKSURLKeySet(t *testing.T) {
+	srv := newJWKSTestServer(t, "a")
+	ks := NewJWKSURLKeySet(srv.URL, JWKSURLOptions{MinRefreshDelay: time.Nanosecond})
+	ctx := context.Background()
+
+	keys, err := ks.Keys(ctx, "a")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Keys(a) = %v, %v, want 1 key", keys, err)
+	}
+	if _, err := ks.Keys(ctx, "a"); err != nil || srv.fetches.Load() != 1 {
+		t.Fatalf("cached Keys(a) fetched %d times, err %v", srv.fetches.Load(), err)
+	}
+
+	// Unknown key ids trigger a refetch, for key rotation.
+	srv.set([]string{"a", "b"}, nil)
+	keys, err = ks.Keys(ctx, "b")
+	if err != nil || len(keys) != 1 || keys[0].ID != "b" {
+		t.Fatalf("Keys(b) = %v, %v, want key b", keys, err)
+	}
+	if n := srv.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
+
+func TestJWKSURLKeySetDefaultTimeout(t *testing.T) {
+	ks := NewJWKSURLKeySet("http://example.com", JWKSURLOptions{}).(*jwksURLKeySet)
+	if ks.opts.HttpClient.Timeout != defaultJWKSTimeout {
+		t.Errorf("timeout = %v, want %v", ks.opts.HttpClient.Timeout, defaultJWKSTimeout)
+	}
+}
+
+func TestJWKSURLKeySetConcurrentRefresh(t *testing.T) {
+	srv := newJWKSTestServer(t, "a")
+	ks := NewJWKSURLKeySet(srv.URL, JWKSURLOptions{CacheTTL: 50 * time.Millisecond, MinRefreshDelay: time.Nanosecond})
+	ctx := context.Background()
+	if _, err := ks.Keys(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	block := make(chan struct{})
+	srv.set([]string{"a", "b"}, block)
+
+	// Stale keys are served while they are refreshed.
+	keys, err := ks.Keys(ctx, "a")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Keys(a) during refresh = %v, %v, want 1 key", keys, err)
+	}
+
+	// Unknown key ids wait for the refresh in progress instead of fetching
+	// again.
+	var wg sync.WaitGroup
+	results := make(chan []JWTKey, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys, err := ks.Keys(ctx, "b")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- keys
+		}()
+	}
+
+	// Callers give up on a refresh when their context is done.
+	cancelCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if _, err := ks.Keys(cancelCtx, "b"); err != context.DeadlineExceeded {
+		t.Errorf("Keys with canceled context err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(block)
+	wg.Wait()
+	close(results)
+	for keys := range results {
+		if len(keys) != 1 || keys[0].ID != "b" {
+			t.Errorf("Keys(b) = %v, want key b", keys)
+		}
+	}
+	if n := srv.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
+
+func TestJWKSURLKeySetFetchError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ks := NewJWKSURLKeySet(srv.URL, JWKSURLOptions{})
+	if _, err := ks.Keys(context.Background(), "a"); err == nil {
+		t.Error("Keys() err = nil, want fetch error")
+	}
+	// The error is kept until the next fetch is allowed.
+	if _, err := ks.Keys(context.Background(), "a"); err == nil {
+		t.Error("Keys() err = nil, want cached fetch error")
+	}
+}
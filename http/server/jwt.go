@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxJWTNumericDate bounds numeric date claims so that their seconds fit
+	// in an int64.
+	maxJWTNumericDate = 1 << 62
+)
+
+var (
+	ErrJWTMalformed        = errors.New("malformed jwt")
+	ErrJWTAlgorithm        = errors.New("jwt algorithm not allowed")
+	ErrJWTSignature        = errors.New("invalid jwt signature")
+	ErrJWTExpired          = errors.New("jwt expired")
+	ErrJWTNotValidYet      = errors.New("jwt not valid yet")
+	ErrJWTInvalidIssuer    = errors.New("invalid jwt issuer")
+	ErrJWTInvalidAudience  = errors.New("invalid jwt audience")
+	ErrJWTMissingExpiresAt = errors.New("jwt has no expiration time")
+)
+
+// JWTKey is a key verifying JWTs. Key is a []byte secret for the HS
+// algorithms, an *rsa.PublicKey for the RS and PS algorithms and an
+// *ecdsa.PublicKey for the ES algorithms. An empty Algorithm allows all
+// algorithms of the key type.
+type JWTKey struct {
+	ID        string
+	Algorithm string
+	Key       any
+}
+
+// JWTKeySet provides the keys verifying JWTs.
+type JWTKeySet interface {
+	// Keys returns the keys with the key id kid, or all keys if kid is empty.
+	Keys(ctx context.Context, kid string) ([]JWTKey, error)
+}
+
+type staticJWTKeySet []JWTKey
+
+// NewStaticJWTKeySet returns a key set of fixed keys.
+func NewStaticJWTKeySet(keys ...JWTKey) JWTKeySet {
+	return staticJWTKeySet(keys)
+}
+
+func (s staticJWTKeySet) Keys(_ context.Context, kid string) ([]JWTKey, error) {
+	return filterJWTKeys(s, kid), nil
+}
+
+func filterJWTKeys(keys []JWTKey, kid string) []JWTKey {
+	if kid == "" {
+		return keys
+	}
+	var filtered []JWTKey
+	for _, key := range keys {
+		if key.ID == kid {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
+
+// JWTOptions configures the verification of JWTs.
+type JWTOptions struct {
+	Keys JWTKeySet
+	// Algorithms are the allowed algorithms, defaulting to all supported
+	// ones: HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512,
+	// ES256, ES384 and ES512. The key type must match the algorithm.
+	Algorithms []string
+
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway is the allowed clock skew for the exp, nbf and iat claims.
+	Leeway time.Duration
+	// AllowMissingExpiresAt accepts tokens without an exp claim.
+	AllowMissingExpiresAt bool
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// VerifyJWT verifies the signature and registered claims of token and returns
+// its claims. Numeric claims are json.Number values.
+func VerifyJWT(ctx context.Context, token string, opts JWTOptions) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if !isAllowedJWTAlgorithm(header.Algorithm, opts.Algorithms) {
+		return nil, ErrJWTAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	keys, err := opts.Keys.Keys(ctx, header.KeyID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting jwt keys")
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		if verifyJWTSignature(header.Algorithm, key.Key, signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrJWTSignature
+	}
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	claims := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(claimsBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err := validateJWTClaims(claims, opts, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func isAllowedJWTAlgorithm(alg string, allowed []string) bool {
+	if _, ok := jwtHashes[alg]; !ok {
+		return false
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	jwtHashes = map[string]crypto.Hash{
+		"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+		"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+		"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+		"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	}
+	jwtCurveBits = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+)
+
+func verifyJWTSignature(alg string, key any, signingInput, signature []byte) bool {
+	hash := jwtHashes[alg]
+	digest := func() []byte {
+		h := hash.New()
+		h.Write(signingInput)
+		return h.Sum(nil)
+	}
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signingInput)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest(), signature) == nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(pub, hash, digest(), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != jwtCurveBits[alg] {
+			return false
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest(), r, s)
+	default:
+		return false
+	}
+}
+
+func validateJWTClaims(claims map[string]any, opts JWTOptions, now time.Time) error {
+	exp, ok, err := numericDateClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok && !opts.AllowMissingExpiresAt {
+		return ErrJWTMissingExpiresAt
+	}
+	if ok && !now.Before(exp.Add(opts.Leeway)) {
+		return ErrJWTExpired
+	}
+	if nbf, ok, err := numericDateClaim(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(opts.Leeway).Before(nbf) {
+		return ErrJWTNotValidYet
+	}
+	if iat, ok, err := numericDateClaim(claims, "iat"); err != nil {
+		return err
+	} else if ok && now.Add(opts.Leeway).Before(iat) {
+		return ErrJWTNotValidYet
+	}
+
+	if opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != opts.Issuer {
+			return ErrJWTInvalidIssuer
+		}
+	}
+	if opts.Audience != "" {
+		found := false
+		for _, aud := range stringsClaim(claims, "aud") {
+			if aud == opts.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrJWTInvalidAudience
+		}
+	}
+	return nil
+}
+
+func numericDateClaim(claims map[string]any, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, errors.Wrapf(ErrJWTMalformed, "claim %s is not a number", name)
+	}
+	f, err := n.Float64()
+	if err != nil || math.IsNaN(f) || math.Abs(f) > maxJWTNumericDate {
+		return time.Time{}, false, errors.Wrapf(ErrJWTMalformed, "claim %s is not a number", name)
+	}
+	// Seconds and nanoseconds are converted separately, as nanoseconds since
+	// the epoch overflow an int64 after 2262.
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true, nil
+}
+
+// stringsClaim returns a claim that is either a string or an array of
+// strings.
+func stringsClaim(claims map[string]any, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerBytes) + "." + base64.RawURLEncoding.EncodeToString(claimsBytes)
+
+	var signature []byte
+	if alg != "none" {
+		hash := jwtHashes[alg]
+		h := hash.New()
+		h.Write([]byte(signingInput))
+		digest := h.Sum(nil)
+		switch alg[:2] {
+		case "HS":
+			mac := hmac.New(hash.New, key.([]byte))
+			mac.Write([]byte(signingInput))
+			signature = mac.Sum(nil)
+		case "RS":
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), hash, digest)
+		case "PS":
+			signature, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		case "ES":
+			priv := key.(*ecdsa.PrivateKey)
+			size := (priv.Curve.Params().BitSize + 7) / 8
+			r, s, signErr := ecdsa.Sign(rand.Reader, priv, digest)
+			err = signErr
+			signature = make([]byte, 2*size)
+			if err == nil {
+				r.FillBytes(signature[:size])
+				s.FillBytes(signature[size:])
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWTAlgorithms(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicBytes, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]any{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name       string
+		token      string
+		keys       []JWTKey
+		algorithms []string
+		wantErr    error
+	}{
+		{
+			name:  "HS256",
+			token: signTestJWT(t, "HS256", "", secret, claims),
+			keys:  []JWTKey{{Key: secret}},
+		},
+		{
+			name:  "RS256",
+			token: signTestJWT(t, "RS256", "", rsaKey, claims),
+			keys:  []JWTKey{{Key: &rsaKey.PublicKey}},
+		},
+		{
+			name:  "PS256",
+			token: signTestJWT(t, "PS256", "", rsaKey, claims),
+			keys:  []JWTKey{{Key: &rsaKey.PublicKey}},
+		},
+		{
+			name:  "ES256",
+			token: signTestJWT(t, "ES256", "", ecKey, claims),
+			keys:  []JWTKey{{Key: &ecKey.PublicKey}},
+		},
+		{
+			name:    "none",
+			token:   signTestJWT(t, "none", "", nil, claims),
+			keys:    []JWTKey{{Key: secret}},
+			wantErr: ErrJWTAlgorithm,
+		},
+		{
+			name:    "wrong secret",
+			token:   signTestJWT(t, "HS256", "", []byte("other"), claims),
+			keys:    []JWTKey{{Key: secret}},
+			wantErr: ErrJWTSignature,
+		},
+		{
+			// An HMAC signed with the public key must not verify against the
+			// RSA key.
+			name:    "HS256 with an RSA public key",
+			token:   signTestJWT(t, "HS256", "", rsaPublicBytes, claims),
+			keys:    []JWTKey{{Key: &rsaKey.PublicKey}},
+			wantErr: ErrJWTSignature,
+		},
+		{
+			name:       "algorithm not allowed",
+			token:      signTestJWT(t, "HS256", "", secret, claims),
+			keys:       []JWTKey{{Key: secret}},
+			algorithms: []string{"RS256"},
+			wantErr:    ErrJWTAlgorithm,
+		},
+		{
+			name:    "key algorithm mismatch",
+			token:   signTestJWT(t, "HS384", "", secret, claims),
+			keys:    []JWTKey{{Algorithm: "HS256", Key: secret}},
+			wantErr: ErrJWTSignature,
+		},
+		{
+			name:  "key id",
+			token: signTestJWT(t, "HS256", "b", secret, claims),
+			keys:  []JWTKey{{ID: "a", Key: []byte("other")}, {ID: "b", Key: secret}},
+		},
+		{
+			name:    "unknown key id",
+			token:   signTestJWT(t, "HS256", "c", secret, claims),
+			keys:    []JWTKey{{ID: "a", Key: secret}},
+			wantErr: ErrJWTSignature,
+		},
+		{
+			name:    "malformed",
+			token:   "a.b",
+			keys:    []JWTKey{{Key: secret}},
+			wantErr: ErrJWTMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyJWT(context.Background(), tt.token, JWTOptions{Keys: NewStaticJWTKeySet(tt.keys...), Algorithms: tt.algorithms})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got["sub"] != "alice" {
+				t.Errorf("sub = %v, want alice", got["sub"])
+			}
+		})
+	}
+}
+
+func TestVerifyJWTUnknownJWKSKeyID(t *testing.T) {
+	srv := newJWKSTestServer(t, "a")
+	keys := NewJWKSURLKeySet(srv.URL, JWKSURLOptions{})
+	claims := map[string]any{"exp": time.Now().Add(time.Hour).Unix()}
+
+	if _, err := VerifyJWT(context.Background(), signTestJWT(t, "HS256", "a", []byte("secret"), claims), JWTOptions{Keys: keys}); err != nil {
+		t.Fatalf("known key id: err = %v", err)
+	}
+	if _, err := VerifyJWT(context.Background(), signTestJWT(t, "HS256", "b", []byte("secret"), claims), JWTOptions{Keys: keys}); !errors.Is(err, ErrJWTSignature) {
+		t.Errorf("unknown key id: err = %v, want %v", err, ErrJWTSignature)
+	}
+}
+
+func TestVerifyJWTClaims(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	hour := now.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		opts    JWTOptions
+		wantErr error
+	}{
+		{name: "valid", claims: map[string]any{"exp": hour}},
+		{name: "fractional exp", claims: map[string]any{"exp": float64(hour) + 0.5}},
+		{name: "exp after 2262", claims: map[string]any{"exp": 9999999999}},
+		{name: "expired", claims: map[string]any{"exp": now.Add(-time.Minute).Unix()}, wantErr: ErrJWTExpired},
+		{name: "expired within leeway", claims: map[string]any{"exp": now.Add(-time.Minute).Unix()}, opts: JWTOptions{Leeway: 2 * time.Minute}},
+		{name: "missing exp", claims: map[string]any{}, wantErr: ErrJWTMissingExpiresAt},
+		{name: "missing exp allowed", claims: map[string]any{}, opts: JWTOptions{AllowMissingExpiresAt: true}},
+		{name: "exp not a number", claims: map[string]any{"exp": "tomorrow"}, wantErr: ErrJWTMalformed},
+		{name: "exp out of range", claims: map[string]any{"exp": 1e300}, wantErr: ErrJWTMalformed},
+		{name: "nbf", claims: map[string]any{"exp": hour, "nbf": now.Add(-time.Minute).Unix()}},
+		{name: "nbf in the future", claims: map[string]any{"exp": hour, "nbf": now.Add(time.Minute).Unix()}, wantErr: ErrJWTNotValidYet},
+		{name: "nbf within leeway", claims: map[string]any{"exp": hour, "nbf": now.Add(time.Minute).Unix()}, opts: JWTOptions{Leeway: 2 * time.Minute}},
+		{name: "nbf after 2262", claims: map[string]any{"exp": 99999999999, "nbf": 99999999990}, wantErr: ErrJWTNotValidYet},
+		{name: "iat in the future", claims: map[string]any{"exp": hour, "iat": now.Add(time.Minute).Unix()}, wantErr: ErrJWTNotValidYet},
+		{name: "iat within leeway", claims: map[string]any{"exp": hour, "iat": now.Add(time.Minute).Unix()}, opts: JWTOptions{Leeway: 2 * time.Minute}},
+		{name: "issuer", claims: map[string]any{"exp": hour, "iss": "a"}, opts: JWTOptions{Issuer: "a"}},
+		{name: "wrong issuer", claims: map[string]any{"exp": hour, "iss": "b"}, opts: JWTOptions{Issuer: "a"}, wantErr: ErrJWTInvalidIssuer},
+		{name: "missing issuer", claims: map[string]any{"exp": hour}, opts: JWTOptions{Issuer: "a"}, wantErr: ErrJWTInvalidIssuer},
+		{name: "audience", claims: map[string]any{"exp": hour, "aud": "api"}, opts: JWTOptions{Audience: "api"}},
+		{name: "audience array", claims: map[string]any{"exp": hour, "aud": []string{"web", "api"}}, opts: JWTOptions{Audience: "api"}},
+		{name: "wrong audience", claims: map[string]any{"exp": hour, "aud": []string{"web"}}, opts: JWTOptions{Audience: "api"}, wantErr: ErrJWTInvalidAudience},
+		{name: "missing audience", claims: map[string]any{"exp": hour}, opts: JWTOptions{Audience: "api"}, wantErr: ErrJWTInvalidAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Keys = NewStaticJWTKeySet(JWTKey{Key: secret})
+			_, err := VerifyJWT(context.Background(), signTestJWT(t, "HS256", "", secret, tt.claims), tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthInvalidCredentials(t *testing.T) {
+	secret := []byte("secret")
+	e := NewWithOptions(Options{
+		LoggerWriter: io.Discard,
+		Authenticators: []Authenticator{
+			NewJWTAuthenticator(JWTAuthOptions{JWTOptions: JWTOptions{Keys: NewStaticJWTKeySet(JWTKey{Key: secret})}}),
+			NewAPIKeyAuthenticator(APIKeyAuthOptions{Keys: map[string]Principal{"key": {Subject: "service"}}}),
+		},
+	})
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, GetContext(c).Principal.Subject)
+	}, RequireAuth())
+
+	tests := []struct {
+		name          string
+		header        string
+		value         string
+		wantStatus    int
+		wantChallenge string
+	}{
+		{name: "valid jwt", header: web.HeaderAuthorization, value: "Bearer " + signTestJWT(t, "HS256", "", secret, map[string]any{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}), wantStatus: http.StatusOK},
+		{name: "invalid jwt", header: web.HeaderAuthorization, value: "Bearer invalid", wantStatus: http.StatusUnauthorized, wantChallenge: `Bearer error="invalid_token"`},
+		{name: "valid api key", header: defaultAPIKeyHeader, value: "key", wantStatus: http.StatusOK},
+		{name: "invalid api key", header: defaultAPIKeyHeader, value: "invalid", wantStatus: http.StatusUnauthorized},
+		{name: "no credentials", wantStatus: http.StatusUnauthorized, wantChallenge: "Bearer"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get(web.HeaderWWWAuthenticate); got != tt.wantChallenge {
+			t.Errorf("%s: %s = %q, want %q", tt.name, web.HeaderWWWAuthenticate, got, tt.wantChallenge)
+		}
+	}
+}
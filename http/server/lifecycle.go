@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultGracefulShutdownTimeout = 10 * time.Second
+	defaultHookTimeout             = 10 * time.Second
+)
+
+// Hook is a function run when the server starts or shuts down.
+type Hook struct {
+	Name string
+	// Fn must return once ctx is done. A hook still running at its timeout
+	// fails, but Fn is not stopped and keeps running in the background.
+	Fn func(ctx context.Context) error
+	// Timeout is the deadline of the hook's context. It defaults to 10s.
+	Timeout time.Duration
+}
+
+// runHooks runs hooks in order, each with its own deadline. If stopOnError is
+// true it returns the first error, otherwise all hooks are run and the errors
+// are joined.
+func runHooks(hooks []Hook, stopOnError bool) error {
+	var errs []error
+	for i, hook := range hooks {
+		if err := runHook(hook); err != nil {
+			name := hook.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			err = fmt.Errorf("hook %s: %w", name, err)
+			if stopOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// runHook runs hook until it returns or its context is done. The goroutine
+// of a hook ignoring its context outlives runHook.
+func runHook(hook Hook) error {
+	if hook.Fn == nil {
+		return nil
+	}
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- hook.Fn(ctx)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsShuttingDown reports whether a server started with StartWithOptions is
+// shutting down, including its drain period.
+func IsShuttingDown(s *Server) bool {
+	return s.shuttingDown.Load()
+}
+
+// joinErrors is like errors.Join but returns the error itself if it is the
+// only non-nil one.
+func joinErrors(errs []error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	if len(nonNil) == 1 {
+		return nonNil[0]
+	}
+	return errors.Join(nonNil...)
+}
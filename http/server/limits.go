@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 60 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultMaxHeaderBytes    = 1 << 20
+	defaultBodyLimit         = 4 << 20
+)
+
+// configureHttpServers applies the timeouts and header size limit of opts to
+// the http servers of e.
+func configureHttpServers(e *echo.Echo, opts Options) {
+	readHeaderTimeout := durationWithDefault(opts.ReadHeaderTimeout, defaultReadHeaderTimeout)
+	readTimeout := durationWithDefault(opts.ReadTimeout, defaultReadTimeout)
+	writeTimeout := durationWithDefault(opts.WriteTimeout, defaultWriteTimeout)
+	idleTimeout := durationWithDefault(opts.IdleTimeout, defaultIdleTimeout)
+	maxHeaderBytes := opts.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultMaxHeaderBytes
+	}
+
+	for _, s := range []*http.Server{e.Server, e.TLSServer} {
+		s.ReadHeaderTimeout = readHeaderTimeout
+		s.ReadTimeout = readTimeout
+		s.WriteTimeout = writeTimeout
+		s.IdleTimeout = idleTimeout
+		s.MaxHeaderBytes = maxHeaderBytes
+	}
+}
+
+// durationWithDefault returns d, or def if d is zero. Negative durations
+// disable the timeout and are returned as zero.
+func durationWithDefault(d, def time.Duration) time.Duration {
+	switch {
+	case d == 0:
+		return def
+	case d < 0:
+		return 0
+	default:
+		return d
+	}
+}
+
+// newBodyLimitMiddleware returns a middleware which limits request bodies to
+// the limit configured for the matched route in routeLimits, falling back to
+// limit. A negative limit disables the limit for the route. Requests with a
+// larger Content-Length are rejected before the handler runs; other bodies
+// fail on read with an error that the error handler turns into a 413.
+func newBodyLimitMiddleware(limit int64, routeLimits map[string]int64) echo.MiddlewareFunc {
+	if limit == 0 {
+		limit = defaultBodyLimit
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			l := limit
+			if routeLimit, ok := routeLimits[c.Path()]; ok {
+				l = routeLimit
+			}
+			if l < 0 {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.ContentLength > l {
+				return echo.ErrStatusRequestEntityTooLarge
+			}
+			if req.Body != nil && req.Body != http.NoBody {
+				req.Body = http.MaxBytesReader(c.Response(), req.Body, l)
+			}
+			return next(c)
+		}
+	}
+}
+
+// isBodyTooLarge reports whether err was caused by reading a request body
+// beyond its limit. Binding wraps such errors in a 400 echo.HTTPError.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
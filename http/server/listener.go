@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+const (
+	defaultTLSCertReloadInterval = 10 * time.Second
+)
+
+// prepareServer creates the listener of the server and returns a function
+// serving on it until the server is shut down.
+func prepareServer(e *echo.Echo, port int, opts StartOptions) (func() error, error) {
+	l, err := newServerListener(port, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newServerTLSConfig(opts)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		if !e.DisableHTTP2 {
+			tlsConfig.NextProtos = appendIfMissing(tlsConfig.NextProtos, "h2")
+		}
+		tlsConfig.NextProtos = appendIfMissing(tlsConfig.NextProtos, "http/1.1")
+		e.TLSServer.TLSConfig = tlsConfig
+		e.TLSListener = tls.NewListener(l, tlsConfig)
+		return func() error { return e.StartServer(e.TLSServer) }, nil
+	}
+
+	e.Listener = l
+	if opts.H2C {
+		return func() error { return e.StartH2CServer("", &http2.Server{}) }, nil
+	}
+	return func() error { return e.StartServer(e.Server) }, nil
+}
+
+func newServerListener(port int, opts StartOptions) (net.Listener, error) {
+	if opts.Listener != nil {
+		return opts.Listener, nil
+	}
+
+	if opts.UnixSocket != "" {
+		// Remove a stale socket left behind by a previous process.
+		if fi, err := os.Stat(opts.UnixSocket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(opts.UnixSocket); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", opts.UnixSocket)
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(opts.Address, strconv.Itoa(port)))
+}
+
+func newServerTLSConfig(opts StartOptions) (*tls.Config, error) {
+	if opts.TLSCertFile == "" && opts.TLSKeyFile == "" {
+		if opts.TLSConfig == nil {
+			return nil, nil
+		}
+		return opts.TLSConfig.Clone(), nil
+	}
+	if opts.TLSCertFile == "" || opts.TLSKeyFile == "" {
+		return nil, errors.New("both TLSCertFile and TLSKeyFile must be set")
+	}
+
+	interval := opts.TLSCertReloadInterval
+	if interval == 0 {
+		interval = defaultTLSCertReloadInterval
+	}
+	reloader, err := newCertReloader(opts.TLSCertFile, opts.TLSKeyFile, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.TLSConfig != nil {
+		tlsConfig = opts.TLSConfig.Clone()
+	}
+	tlsConfig.Certificates = nil
+	tlsConfig.GetCertificate = reloader.getCertificate
+	return tlsConfig, nil
+}
+
+// certReloader reloads a certificate and key pair when their files change.
+// Files are checked at most once per interval, during TLS handshakes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	checkedAt   time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.checkedAt = time.Now()
+	if r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.interval > 0 && time.Since(r.checkedAt) >= r.interval {
+		// Keep serving the previous certificate if the new files are invalid,
+		// for example while they are being written.
+		_ = r.reload()
+	}
+	return r.cert, nil
+}
+
+func appendIfMissing(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
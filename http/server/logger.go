@@ -13,7 +13,7 @@ func newLogger(w io.Writer) *zerolog.Logger {
 		zerolog.ConsoleWriter{
 			Out:         w,
 			TimeFormat:  "02 Jan 06 15:04:05 MST",
-			FieldsOrder: []string{"status", "method", "uri", "error", "request_id", "latency", "size"},
+			FieldsOrder: []string{"status", "method", "uri", "error", "request_id", "trace_id", "span_id", "client_ip", "latency", "size"},
 		},
 	).
 		With().
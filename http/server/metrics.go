@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gpahal/golib/metrics"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultMetricsPath = "/metrics"
+	unmatchedRoute     = "<unmatched>"
+	otherMethod        = "OTHER"
+)
+
+func newMetricsMiddleware(registry metrics.Registry) echo.MiddlewareFunc {
+	requests := registry.Counter("http_server_requests_total", "Total number of HTTP requests handled.", "method", "route", "status_class")
+	duration := registry.Histogram("http_server_request_duration_seconds", "Duration of HTTP requests in seconds.", metrics.DefaultBuckets, "method", "route", "status_class")
+	inFlight := registry.Gauge("http_server_requests_in_flight", "Number of HTTP requests currently being handled.", "method", "route")
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := methodLabel(c.Request().Method)
+			route := c.Path()
+			if route == "" {
+				route = unmatchedRoute
+			}
+
+			inFlight.Inc(method, route)
+			defer inFlight.Dec(method, route)
+
+			start := time.Now()
+			err := next(c)
+
+			statusClass := statusClass(responseStatus(c, err))
+			requests.Inc(method, route, statusClass)
+			duration.Observe(time.Since(start).Seconds(), method, route, statusClass)
+			return err
+		}
+	}
+}
+
+// methodLabel returns the method label of a request method. Non standard
+// methods are labeled OTHER so that clients cannot create unbounded series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return otherMethod
+	}
+}
+
+// MetricsHandler returns a handler which writes the metrics of exposer in the
+// Prometheus text exposition format.
+func MetricsHandler(exposer metrics.Exposer) echo.HandlerFunc {
+	return echo.WrapHandler(metrics.Handler(exposer))
+}
+
+// responseStatus returns the status code that will be sent for the response
+// once err, if any, has been handled by the error handler.
+func responseStatus(c echo.Context, err error) int {
+	if err == nil {
+		return c.Response().Status
+	}
+	if c.Response().Committed {
+		return c.Response().Status
+	}
+	if isBodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem.status()
+	}
+	if he, ok := err.(*echo.HTTPError); ok {
+		if herr, ok := he.Internal.(*echo.HTTPError); ok {
+			return herr.Code
+		}
+		return he.Code
+	}
+	return http.StatusInternalServerError
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gpahal/golib/metrics"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	registry := metrics.NewRegistry()
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, Metrics: registry})
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/fail", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad")
+	})
+	e.GET("/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/fail", "/panic", "/missing/1", "/missing/2"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	tests := []struct {
+		route       string
+		statusClass string
+		want        float64
+	}{
+		{route: "/users/:id", statusClass: "2xx", want: 2},
+		{route: "/fail", statusClass: "4xx", want: 1},
+		{route: "/panic", statusClass: "5xx", want: 1},
+		{route: unmatchedRoute, statusClass: "4xx", want: 2},
+		{route: "/users/1", statusClass: "2xx", want: 0},
+		{route: "/missing/1", statusClass: "4xx", want: 0},
+	}
+	for _, tt := range tests {
+		if got := registry.Value("http_server_requests_total", http.MethodGet, tt.route, tt.statusClass); got != tt.want {
+			t.Errorf("requests of %s %s = %v, want %v", tt.route, tt.statusClass, got, tt.want)
+		}
+		if got := registry.Count("http_server_request_duration_seconds", http.MethodGet, tt.route, tt.statusClass); got != uint64(tt.want) {
+			t.Errorf("duration observations of %s %s = %d, want %v", tt.route, tt.statusClass, got, tt.want)
+		}
+	}
+	if got := registry.Value("http_server_requests_in_flight", http.MethodGet, "/users/:id"); got != 0 {
+		t.Errorf("in flight requests = %v, want 0", got)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get(echo.HeaderContentType); got != metrics.ContentType {
+		t.Errorf("metrics Content-Type = %q, want %q", got, metrics.ContentType)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`http_server_requests_total{method="GET",route="/users/:id",status_class="2xx"} 2`,
+		`http_server_requests_total{method="GET",route="<unmatched>",status_class="4xx"} 2`,
+		`http_server_request_duration_seconds_count{method="GET",route="/users/:id",status_class="2xx"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `route="/users/1"`) || strings.Contains(body, `route="/missing/1"`) {
+		t.Errorf("metrics contain raw paths:\n%s", body)
+	}
+}
+
+func TestMetricsMethodLabel(t *testing.T) {
+	registry := metrics.NewRegistry()
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, Metrics: registry})
+	e.Any("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodPost, "PROPFIND", "X-RANDOM-1", "X-RANDOM-2", "get"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+
+	tests := []struct {
+		method string
+		want   float64
+	}{
+		{method: http.MethodGet, want: 1},
+		{method: http.MethodPost, want: 1},
+		{method: otherMethod, want: 4},
+		{method: "PROPFIND", want: 0},
+		{method: "get", want: 0},
+	}
+	for _, tt := range tests {
+		// Methods unknown to the router are answered with a 405 status.
+		got := registry.Value("http_server_requests_total", tt.method, "/", "2xx") + registry.Value("http_server_requests_total", tt.method, "/", "4xx")
+		if got != tt.want {
+			t.Errorf("requests of method %s = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"time"
+
+	"github.com/dustin/go-humanize"
+	web "github.com/gpahal/golib/http"
+	"github.com/gpahal/golib/tracing"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+)
+
+// TrailingSlash is the trailing slash handling applied before routing.
+type TrailingSlash int
+
+const (
+	// TrailingSlashRemove removes trailing slashes from request paths.
+	TrailingSlashRemove TrailingSlash = iota
+	// TrailingSlashAdd adds a trailing slash to request paths.
+	TrailingSlashAdd
+	// TrailingSlashKeep leaves request paths untouched.
+	TrailingSlashKeep
+)
+
+// MiddlewarePosition is the position of a custom middleware in the middleware
+// stack built by NewWithOptions. The stack is, from outermost to innermost:
+// trailing slash handling, MiddlewarePositionPre, routing, request id,
+// tracing, metrics, MiddlewarePositionBeforeContext, Context creation,
+// security headers, CORS, compression, MiddlewarePositionAfterContext,
+// request logging, recovery, MiddlewarePositionAfterRecovery, rate limit,
+// body limit, sessions, authentication, CSRF, idempotency, timeout and
+// MiddlewarePositionAfterTimeout.
+type MiddlewarePosition int
+
+const (
+	// MiddlewarePositionPre runs before routing, so c.Path() is not set yet.
+	MiddlewarePositionPre MiddlewarePosition = iota
+	// MiddlewarePositionBeforeContext runs before the server Context is
+	// created, so GetContext cannot be used.
+	MiddlewarePositionBeforeContext
+	// MiddlewarePositionAfterContext runs before requests are logged and
+	// panics are recovered.
+	MiddlewarePositionAfterContext
+	// MiddlewarePositionAfterRecovery runs after requests are logged and
+	// panics are recovered, but outside of the timeout.
+	MiddlewarePositionAfterRecovery
+	// MiddlewarePositionAfterTimeout runs innermost, within the timeout.
+	MiddlewarePositionAfterTimeout
+)
+
+// Middleware is a custom middleware inserted at Position.
+type Middleware struct {
+	Position   MiddlewarePosition
+	Middleware echo.MiddlewareFunc
+}
+
+func useMiddleware(e *echo.Echo, mws []Middleware, position MiddlewarePosition) {
+	for _, mw := range mws {
+		if mw.Position != position || mw.Middleware == nil {
+			continue
+		}
+
+		if position == MiddlewarePositionPre {
+			e.Pre(mw.Middleware)
+		} else {
+			e.Use(mw.Middleware)
+		}
+	}
+}
+
+func defaultMiddleware(mw echo.MiddlewareFunc, newDefault func() echo.MiddlewareFunc) echo.MiddlewareFunc {
+	if mw != nil {
+		return mw
+	}
+	return newDefault()
+}
+
+func newTrailingSlashMiddleware(trailingSlash TrailingSlash, redirectCode int) echo.MiddlewareFunc {
+	config := middleware.TrailingSlashConfig{RedirectCode: redirectCode}
+	switch trailingSlash {
+	case TrailingSlashAdd:
+		return middleware.AddTrailingSlashWithConfig(config)
+	case TrailingSlashKeep:
+		return nil
+	default:
+		return middleware.RemoveTrailingSlashWithConfig(config)
+	}
+}
+
+func newContextMiddleware(s *Server, opts Options, proxies *proxyResolver) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			client := proxies.resolve(c.Request())
+			sctx := &Context{Context: c, Validator: opts.Validator, Translator: findTranslator(opts.UniversalTranslator, c.Request().Header.Get(web.HeaderAcceptLanguage)), ConfigRaw: opts.Config, ServerLoggerWriter: opts.LoggerWriter, ServerLogger: newContextLogger(c, opts.Logger), Span: tracing.SpanFromContext(ctx), SpanContext: tracing.SpanContextFromContext(ctx), Services: opts.Services, ClientIP: client.ip, ClientScheme: client.scheme, ClientHost: client.host, server: s, errorMapper: opts.ErrorMapper, scopedServices: newScopedServices(ctx)}
+			defer sctx.releaseScopedServices()
+			return next(sctx)
+		}
+	}
+}
+
+func newRequestLoggerMiddleware() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogMethod:       true,
+		LogURI:          true,
+		LogStatus:       true,
+		LogError:        true,
+		LogLatency:      true,
+		LogResponseSize: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			sctx := GetContext(c)
+			evt := sctx.ServerLogger.Info()
+			if v.Error != nil {
+				evt = sctx.ServerLogger.Error()
+			}
+
+			evt = evt.Int("status", v.Status).Err(v.Error).Str("client_ip", sctx.ClientIP).Str("latency", v.Latency.String())
+			if v.RequestID != "" {
+				evt = evt.Str("request_id", v.RequestID)
+			}
+			if v.ResponseSize > 0 {
+				evt = evt.Str("size", humanize.Bytes(uint64(v.ResponseSize)))
+			}
+
+			evt.Msg("request")
+			return nil
+		},
+	})
+}
+
+// newTimeoutMiddleware returns a timeout middleware which applies the timeout
+// configured for the matched route in routeTimeouts, falling back to timeout.
+// A negative timeout disables the timeout for the route.
+func newTimeoutMiddleware(timeout time.Duration, routeTimeouts map[string]time.Duration, skipper middleware.Skipper) echo.MiddlewareFunc {
+	if skipper == nil {
+		skipper = middleware.DefaultSkipper
+	}
+
+	timeoutMiddlewares := make(map[time.Duration]echo.MiddlewareFunc)
+	for _, d := range routeTimeouts {
+		timeoutMiddlewares[d] = nil
+	}
+	timeoutMiddlewares[timeout] = nil
+	for d := range timeoutMiddlewares {
+		if d > 0 {
+			timeoutMiddlewares[d] = middleware.TimeoutWithConfig(middleware.TimeoutConfig{Timeout: d})
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+
+			d := timeout
+			if routeTimeout, ok := routeTimeouts[c.Path()]; ok {
+				d = routeTimeout
+			}
+			mw := timeoutMiddlewares[d]
+			if mw == nil {
+				return next(c)
+			}
+
+			// The timeout middleware gives handlers an empty header map, which
+			// replaces the values set by outer middleware once the handler
+			// returns, e.g. Vary, so handlers start with a copy of them.
+			header := c.Response().Header().Clone()
+			return mw(func(c echo.Context) error {
+				handlerHeader := c.Response().Header()
+				for k, values := range header {
+					if _, ok := handlerHeader[k]; !ok {
+						handlerHeader[k] = values
+					}
+				}
+				return next(c)
+			})(c)
+		}
+	}
+}
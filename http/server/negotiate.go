@@ -0,0 +1,135 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrNotAcceptable = errors.New("no acceptable response format")
+
+	// mediaTypeAliases are legacy media types accepted for the offers of
+	// Negotiate.
+	mediaTypeAliases = map[string]string{
+		"application/x-msgpack":  web.MIMEApplicationMsgpack,
+		"application/x-protobuf": web.MIMEApplicationProtobuf,
+		web.MIMETextXML:          web.MIMEApplicationXML,
+	}
+)
+
+// NegotiateContentType returns the offer with the highest quality in the
+// Accept header of the request, preferring earlier offers on ties and the
+// first offer if there is no Accept header. It returns an empty string if no
+// offer is acceptable.
+func (c *Context) NegotiateContentType(offers ...string) string {
+	return negotiateContentType(c.Request().Header.Get(web.HeaderAccept), offers)
+}
+
+// Negotiate sends v with status in the format negotiated from the Accept
+// header: JSON, XML, msgpack, or protobuf if v is a proto.Message. It fails
+// with a 406 error if no format is acceptable.
+func (c *Context) Negotiate(status int, v any) error {
+	offers := []string{web.MIMEApplicationJSON, web.MIMEApplicationXML, web.MIMEApplicationMsgpack}
+	m, isProto := v.(proto.Message)
+	if isProto {
+		offers = append(offers, web.MIMEApplicationProtobuf)
+	}
+
+	addVary(c.Response().Header(), web.HeaderAccept)
+	switch c.NegotiateContentType(offers...) {
+	case web.MIMEApplicationJSON:
+		return c.JSON(status, v)
+	case web.MIMEApplicationXML:
+		return c.XML(status, v)
+	case web.MIMEApplicationMsgpack:
+		return c.Msgpack(status, v)
+	case web.MIMEApplicationProtobuf:
+		return c.Protobuf(status, m)
+	default:
+		return NewHttpErrorWithInternal(http.StatusNotAcceptable, ErrNotAcceptable.Error(), ErrNotAcceptable)
+	}
+}
+
+// Msgpack sends v encoded with msgpack.
+func (c *Context) Msgpack(status int, v any) error {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, web.MIMEApplicationMsgpack, b)
+}
+
+// Protobuf sends m encoded with protobuf.
+func (c *Context) Protobuf(status int, m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, web.MIMEApplicationProtobuf, b)
+}
+
+func negotiateContentType(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	type mediaRange struct {
+		typ     string
+		subtype string
+		q       float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if alias, ok := mediaTypeAliases[mediaType]; ok {
+			mediaType = alias
+		}
+
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(qs, 64); err == nil {
+				q = parsed
+			}
+		}
+		typ, subtype, _ := strings.Cut(mediaType, "/")
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		typ, subtype, _ := strings.Cut(offer, "/")
+		// The most specific matching range sets the quality of the offer.
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.typ == typ && r.subtype == subtype:
+				s = 2
+			case r.typ == typ && r.subtype == "*":
+				s = 1
+			case r.typ == "*" && r.subtype == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	jsonXML := []string{web.MIMEApplicationJSON, web.MIMEApplicationXML}
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		{accept: "", offers: jsonXML, want: web.MIMEApplicationJSON},
+		{accept: "application/xml", offers: jsonXML, want: web.MIMEApplicationXML},
+		{accept: "text/xml", offers: jsonXML, want: web.MIMEApplicationXML},
+		{accept: "application/json, application/xml", offers: []string{web.MIMEApplicationXML, web.MIMEApplicationJSON}, want: web.MIMEApplicationXML},
+		{accept: "application/json;q=0.5, application/xml;q=0.9", offers: jsonXML, want: web.MIMEApplicationXML},
+		{accept: "application/json; q=0.5, application/xml", offers: jsonXML, want: web.MIMEApplicationXML},
+		{accept: "application/*;q=0.5, application/xml;q=0.1", offers: jsonXML, want: web.MIMEApplicationJSON},
+		{accept: "*/*;q=0.1, application/xml", offers: jsonXML, want: web.MIMEApplicationXML},
+		{accept: "*/*", offers: jsonXML, want: web.MIMEApplicationJSON},
+		{accept: "application/json;q=0, */*", offers: jsonXML, want: web.MIMEApplicationXML},
+		{accept: "application/json;q=invalid", offers: jsonXML, want: web.MIMEApplicationJSON},
+		{accept: "application/x-msgpack", offers: []string{web.MIMEApplicationJSON, web.MIMEApplicationMsgpack}, want: web.MIMEApplicationMsgpack},
+		{accept: "text/html", offers: jsonXML, want: ""},
+		{accept: "application/json;q=0", offers: jsonXML, want: ""},
+		{accept: "invalid, application/xml", offers: jsonXML, want: web.MIMEApplicationXML},
+		{accept: "*/*", offers: nil, want: ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateContentType(tt.accept, tt.offers); got != tt.want {
+			t.Errorf("negotiateContentType(%q, %v) = %q, want %q", tt.accept, tt.offers, got, tt.want)
+		}
+	}
+}
+
+func TestContextNegotiate(t *testing.T) {
+	type item struct {
+		Name string `json:"name" xml:"name"`
+	}
+	e := NewWithOptions(Options{LoggerWriter: io.Discard})
+	var negotiateErr error
+	e.GET("/", func(c echo.Context) error {
+		negotiateErr = GetContext(c).Negotiate(http.StatusOK, item{Name: "a"})
+		return negotiateErr
+	})
+
+	tests := []struct {
+		accept          string
+		wantStatus      int
+		wantContentType string
+	}{
+		{accept: "", wantStatus: http.StatusOK, wantContentType: web.MIMEApplicationJSON},
+		{accept: "application/xml", wantStatus: http.StatusOK, wantContentType: web.MIMEApplicationXML},
+		{accept: "application/msgpack", wantStatus: http.StatusOK, wantContentType: web.MIMEApplicationMsgpack},
+		{accept: "application/protobuf", wantStatus: http.StatusNotAcceptable},
+		{accept: "text/html", wantStatus: http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			req.Header.Set(web.HeaderAccept, tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("Accept %q: status = %d, want %d", tt.accept, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get(web.HeaderVary); got != web.HeaderAccept {
+			t.Errorf("Accept %q: Vary = %q, want %q", tt.accept, got, web.HeaderAccept)
+		}
+		if tt.wantStatus == http.StatusNotAcceptable {
+			var httpErr *echo.HTTPError
+			if !errors.As(negotiateErr, &httpErr) || !errors.Is(httpErr.Internal, ErrNotAcceptable) {
+				t.Errorf("Accept %q: err = %v, want %v", tt.accept, negotiateErr, ErrNotAcceptable)
+			}
+			continue
+		}
+		if got := rec.Header().Get(web.HeaderContentType); got != tt.wantContentType && got != tt.wantContentType+"; charset=UTF-8" {
+			t.Errorf("Accept %q: Content-Type = %q, want %q", tt.accept, got, tt.wantContentType)
+		}
+	}
+}
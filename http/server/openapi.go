@@ -0,0 +1,387 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	openAPIVersion     = "3.1.0"
+	defaultOpenAPIPath = "/openapi.json"
+
+	// UpdateOpenAPISpecEnv is the environment variable which makes
+	// CheckOpenAPISpec write the spec file instead of comparing it.
+	UpdateOpenAPISpecEnv = "UPDATE_OPENAPI_SPEC"
+)
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIOptions struct {
+	Info OpenAPIInfo
+	// Path is the path the spec is served at. It defaults to /openapi.json.
+	Path string
+}
+
+// RouteOptions describe a typed route for the OpenAPI spec.
+type RouteOptions struct {
+	HandleOptions
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	// ErrorStatuses are the error status codes documented in addition to
+	// 400, 422 for requests with validation rules and the default response.
+	ErrorStatuses []int
+}
+
+type routeMeta struct {
+	opts     RouteOptions
+	reqType  reflect.Type
+	respType reflect.Type
+}
+
+// AddRoute registers a typed handler, see Handle, on r and records its
+// request and response types so that it is included in the OpenAPI spec.
+func AddRoute[Req, Resp any](r Router, method, path string, fn func(ctx *Context, req Req) (Resp, error), opts RouteOptions, m ...echo.MiddlewareFunc) *echo.Route {
+	route := r.Add(method, path, HandleWithOptions(fn, opts.HandleOptions), m...)
+	s := r.routeServer()
+	s.routeMetasMu.Lock()
+	defer s.routeMetasMu.Unlock()
+	s.routeMetas[route] = routeMeta{
+		opts:     opts,
+		reqType:  reflect.TypeFor[Req](),
+		respType: reflect.TypeFor[Resp](),
+	}
+	return route
+}
+
+// OpenAPISpec generates an OpenAPI 3.1 document, as indented JSON, for the
+// routes of s registered with AddRoute.
+func OpenAPISpec(s *Server, info OpenAPIInfo) ([]byte, error) {
+	g := newSchemaGenerator()
+	// Reserve the error schema names so that user types with the same names
+	// get qualified names.
+	for name, s := range errorSchemas() {
+		g.schemas[name] = s
+	}
+
+	doc := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    info,
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+
+	// Routes are sorted so that the names given to types with the same name
+	// in different packages don't depend on the order of the routes map.
+	s.routeMetasMu.Lock()
+	defer s.routeMetasMu.Unlock()
+	routes := s.Routes()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	for _, route := range routes {
+		meta, ok := s.routeMetas[route]
+		if !ok {
+			continue
+		}
+
+		path, pathParams := openAPIPath(route.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = g.operation(route.Method, pathParams, meta)
+	}
+
+	doc.Components.Schemas = g.schemas
+
+	bs, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
+
+// OpenAPIHandler returns a handler serving the OpenAPI spec of the server.
+// The spec is generated on the first request, once all routes have been
+// registered.
+func OpenAPIHandler(info OpenAPIInfo) echo.HandlerFunc {
+	var once sync.Once
+	var spec []byte
+	var specErr error
+	return func(c echo.Context) error {
+		once.Do(func() {
+			spec, specErr = OpenAPISpec(GetContext(c).server, info)
+		})
+		if specErr != nil {
+			return specErr
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, spec)
+	}
+}
+
+// CheckOpenAPISpec compares the OpenAPI spec generated for e with the
+// checked-in spec file at path and returns an error with a line diff if they
+// differ. It is meant to be called from tests to catch accidental API changes.
+// If the UPDATE_OPENAPI_SPEC environment variable is set, the spec file is
+// written instead.
+func CheckOpenAPISpec(s *Server, info OpenAPIInfo, path string) error {
+	spec, err := OpenAPISpec(s, info)
+	if err != nil {
+		return err
+	}
+
+	if os.Getenv(UpdateOpenAPISpecEnv) != "" {
+		return os.WriteFile(path, spec, 0o644)
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading OpenAPI spec (set %s=1 to create it)", UpdateOpenAPISpecEnv)
+	}
+	if string(expected) == string(spec) {
+		return nil
+	}
+	return errors.Errorf("OpenAPI spec differs from %s (set %s=1 to update it):\n%s", path, UpdateOpenAPISpecEnv, lineDiff(string(expected), string(spec)))
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas,omitempty"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *schema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+// openAPIPath converts an echo path like /users/:id/* to an OpenAPI path like
+// /users/{id}/{wildcard} and returns the names of its path params.
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		case segment == "*":
+			params = append(params, "*")
+			segments[i] = "{wildcard}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func (g *schemaGenerator) operation(method string, pathParams []string, meta routeMeta) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: meta.opts.OperationID,
+		Summary:     meta.opts.Summary,
+		Description: meta.opts.Description,
+		Tags:        meta.opts.Tags,
+		Deprecated:  meta.opts.Deprecated,
+		Responses:   make(map[string]*openAPIResponse),
+	}
+
+	params, body, validated := g.requestSchemas(meta.reqType)
+	for _, name := range pathParams {
+		p := params["path"][name]
+		if p == nil {
+			p = &openAPIParameter{Name: name, In: "path", Schema: &schema{Type: "string"}}
+		}
+		if name == "*" {
+			p.Name = "wildcard"
+		}
+		p.Required = true
+		op.Parameters = append(op.Parameters, p)
+	}
+	for _, in := range []string{"query", "header"} {
+		names := make([]string, 0, len(params[in]))
+		for name := range params[in] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			op.Parameters = append(op.Parameters, params[in][name])
+		}
+	}
+
+	if body != nil && method != http.MethodGet && method != http.MethodHead && method != http.MethodDelete {
+		op.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content:  map[string]*openAPIMediaType{echo.MIMEApplicationJSON: {Schema: body}},
+		}
+	}
+
+	status := meta.opts.Status
+	isNoContent := meta.respType == reflect.TypeFor[NoContent]()
+	if status == 0 {
+		status = http.StatusOK
+		if isNoContent {
+			status = http.StatusNoContent
+		}
+	}
+	resp := &openAPIResponse{Description: http.StatusText(status)}
+	if !isNoContent {
+		resp.Content = map[string]*openAPIMediaType{echo.MIMEApplicationJSON: {Schema: g.schema(meta.respType)}}
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+
+	errorStatuses := []int{http.StatusBadRequest}
+	if validated {
+		errorStatuses = append(errorStatuses, http.StatusUnprocessableEntity)
+	}
+	errorStatuses = append(errorStatuses, meta.opts.ErrorStatuses...)
+	for _, status := range errorStatuses {
+		op.Responses[strconv.Itoa(status)] = errorResponse(http.StatusText(status))
+	}
+	op.Responses["default"] = errorResponse("Unexpected error")
+	return op
+}
+
+func errorResponse(description string) *openAPIResponse {
+	return &openAPIResponse{
+		Description: description,
+		Content: map[string]*openAPIMediaType{
+			echo.MIMEApplicationJSON:   {Schema: &schema{Ref: schemaRef("Error")}},
+			MIMEApplicationProblemJSON: {Schema: &schema{Ref: schemaRef("Problem")}},
+		},
+	}
+}
+
+func errorSchemas() map[string]*schema {
+	fieldErrors := &schema{Type: "array", Items: &schema{Ref: schemaRef("FieldError")}}
+	return map[string]*schema{
+		"Error": {
+			Type: "object",
+			Properties: map[string]*schema{
+				"error":  {Type: "string"},
+				"errors": fieldErrors,
+			},
+			Required: []string{"error"},
+		},
+		"Problem": {
+			Type: "object",
+			Properties: map[string]*schema{
+				"type":     {Type: "string", Format: "uri-reference"},
+				"title":    {Type: "string"},
+				"status":   {Type: "integer"},
+				"detail":   {Type: "string"},
+				"instance": {Type: "string"},
+				"errors":   fieldErrors,
+			},
+			Required: []string{"type", "title", "status"},
+		},
+		"FieldError": {
+			Type: "object",
+			Properties: map[string]*schema{
+				"field":   {Type: "string"},
+				"tag":     {Type: "string"},
+				"param":   {Type: "string"},
+				"message": {Type: "string"},
+			},
+			Required: []string{"field", "tag", "message"},
+		},
+	}
+}
+
+// lineDiff returns a minimal line diff of a and b, with removed lines
+// prefixed by "-" and added lines by "+".
+func lineDiff(a, b string) string {
+	al := strings.Split(a, "\n")
+	bl := strings.Split(b, "\n")
+
+	prefix := 0
+	for prefix < len(al) && prefix < len(bl) && al[prefix] == bl[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(al)-prefix && suffix < len(bl)-prefix && al[len(al)-1-suffix] == bl[len(bl)-1-suffix] {
+		suffix++
+	}
+	al = al[prefix : len(al)-suffix]
+	bl = bl[prefix : len(bl)-suffix]
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@@ line %d @@\n", prefix+1)
+
+	// Longest common subsequence of the differing middle parts.
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			sb.WriteString(" " + al[i] + "\n")
+			i++
+			j++
+		case i < len(al) && (j == len(bl) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("-" + al[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + bl[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
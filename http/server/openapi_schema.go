@@ -0,0 +1,374 @@
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	MinProperties        *int               `json:"minProperties,omitempty"`
+	MaxProperties        *int               `json:"maxProperties,omitempty"`
+	UniqueItems          bool               `json:"uniqueItems,omitempty"`
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	durationType      = reflect.TypeFor[time.Duration]()
+	rawMessageType    = reflect.TypeFor[json.RawMessage]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+	schemaNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+func schemaRef(name string) string {
+	return "#/components/schemas/" + name
+}
+
+// schemaGenerator generates JSON schemas for Go types, collecting named
+// struct types as component schemas.
+type schemaGenerator struct {
+	schemas map[string]*schema
+	names   map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{schemas: make(map[string]*schema), names: make(map[reflect.Type]string)}
+}
+
+// requestSchemas returns the parameters of a typed handler request type by
+// location, the schema of its body, if any, and whether it has validation
+// rules.
+func (g *schemaGenerator) requestSchemas(typ reflect.Type) (map[string]map[string]*openAPIParameter, *schema, bool) {
+	params := map[string]map[string]*openAPIParameter{"path": {}, "query": {}, "header": {}}
+	typ = derefType(typ)
+	if typ.Kind() != reflect.Struct {
+		return params, g.schema(typ), false
+	}
+
+	body := &schema{Type: "object", Properties: make(map[string]*schema)}
+	validated := false
+	visitFields(typ, func(sf reflect.StructField) {
+		validateTag := sf.Tag.Get("validate")
+		if validateTag != "" && validateTag != "-" {
+			validated = true
+		}
+
+		isParam := false
+		for in, tag := range map[string]string{"path": "param", "query": "query", "header": "header"} {
+			name, _, _ := strings.Cut(sf.Tag.Get(tag), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+
+			isParam = true
+			s := g.schema(sf.Type)
+			required := applyValidateTag(s, sf.Type, validateTag)
+			params[in][name] = &openAPIParameter{Name: name, In: in, Required: required, Schema: s}
+		}
+		if isParam && sf.Tag.Get("json") == "" {
+			return
+		}
+
+		g.addProperty(body, sf)
+	})
+
+	if !validated {
+		validated = hasValidateTags(typ, make(map[reflect.Type]bool))
+	}
+	if len(body.Properties) == 0 {
+		body = nil
+	}
+	return params, body, validated
+}
+
+func (g *schemaGenerator) schema(typ reflect.Type) *schema {
+	typ = derefType(typ)
+
+	switch typ {
+	case timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &schema{Type: "integer", Format: "int64"}
+	case rawMessageType:
+		return &schema{}
+	}
+	if typ.Kind() != reflect.Struct && (typ.Implements(textMarshalerType) || reflect.PointerTo(typ).Implements(textMarshalerType)) {
+		return &schema{Type: "string"}
+	}
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return &schema{Type: "integer", Minimum: ptr(0.0)}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.schema(typ.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schema(typ.Elem())}
+	case reflect.Struct:
+		if typ.Name() == "" {
+			return g.structSchema(typ)
+		}
+		return &schema{Ref: schemaRef(g.componentSchema(typ))}
+	default:
+		return &schema{}
+	}
+}
+
+// componentSchema generates the component schema of a named struct type and
+// returns its name.
+func (g *schemaGenerator) componentSchema(typ reflect.Type) string {
+	if name, ok := g.names[typ]; ok {
+		return name
+	}
+
+	name := schemaNameRegexp.ReplaceAllString(typ.Name(), "_")
+	if _, exists := g.schemas[name]; exists {
+		name = schemaNameRegexp.ReplaceAllString(typ.String(), "_")
+		// Types declared in functions share their package qualified name.
+		for i, base := 2, name; ; i++ {
+			if _, exists := g.schemas[name]; !exists {
+				break
+			}
+			name = base + "_" + strconv.Itoa(i)
+		}
+	}
+	g.names[typ] = name
+	// Reserve the name before generating the properties to support recursive
+	// types.
+	g.schemas[name] = nil
+	g.schemas[name] = g.structSchema(typ)
+	return name
+}
+
+func (g *schemaGenerator) structSchema(typ reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	visitFields(typ, func(sf reflect.StructField) {
+		g.addProperty(s, sf)
+	})
+	return s
+}
+
+func (g *schemaGenerator) addProperty(s *schema, sf reflect.StructField) {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "-" {
+		return
+	}
+	if name == "" {
+		name = sf.Name
+	}
+
+	prop := g.schema(sf.Type)
+	if applyValidateTag(prop, sf.Type, sf.Tag.Get("validate")) {
+		s.Required = append(s.Required, name)
+	}
+	s.Properties[name] = prop
+}
+
+// visitFields calls fn for the exported fields of a struct type, flattening
+// embedded structs without a JSON name like encoding/json does.
+func visitFields(typ reflect.Type, fn func(sf reflect.StructField)) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.Anonymous {
+			name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if ft := derefType(sf.Type); name == "" && ft.Kind() == reflect.Struct {
+				visitFields(ft, fn)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		fn(sf)
+	}
+}
+
+func hasValidateTags(typ reflect.Type, visited map[reflect.Type]bool) bool {
+	typ = derefType(typ)
+	for typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array || typ.Kind() == reflect.Map {
+		typ = derefType(typ.Elem())
+	}
+	if typ.Kind() != reflect.Struct || visited[typ] {
+		return false
+	}
+	visited[typ] = true
+
+	found := false
+	visitFields(typ, func(sf reflect.StructField) {
+		if found {
+			return
+		}
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			found = true
+			return
+		}
+		found = hasValidateTags(sf.Type, visited)
+	})
+	return found
+}
+
+// applyValidateTag maps the validator rules of a field to schema constraints
+// and returns whether the field is required.
+func applyValidateTag(s *schema, typ reflect.Type, tag string) bool {
+	if tag == "" || tag == "-" {
+		return false
+	}
+
+	required := false
+	target := s
+	typ = derefType(typ)
+	for _, rule := range strings.Split(tag, ",") {
+		if strings.Contains(rule, "|") {
+			continue
+		}
+
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "dive":
+			if target.Items == nil && target.AdditionalProperties == nil {
+				return required
+			}
+			if target.Items != nil {
+				target = target.Items
+			} else {
+				target = target.AdditionalProperties
+			}
+			typ = derefType(typ.Elem())
+		case "required":
+			required = required || target == s
+		case "len":
+			applySizeConstraint(target, typ, "min", param)
+			applySizeConstraint(target, typ, "max", param)
+		case "min", "max", "gt", "gte", "lt", "lte":
+			applySizeConstraint(target, typ, name, param)
+		case "oneof":
+			target.Enum = nil
+			for _, value := range strings.Fields(param) {
+				target.Enum = append(target.Enum, enumValue(typ, value))
+			}
+		case "unique":
+			target.UniqueItems = true
+		case "email":
+			target.Format = "email"
+		case "url", "http_url", "uri":
+			target.Format = "uri"
+		case "uuid", "uuid3", "uuid4", "uuid5":
+			target.Format = "uuid"
+		case "ipv4":
+			target.Format = "ipv4"
+		case "ipv6":
+			target.Format = "ipv6"
+		case "hostname", "hostname_rfc1123":
+			target.Format = "hostname"
+		case "alpha":
+			target.Pattern = "^[a-zA-Z]+$"
+		case "alphanum":
+			target.Pattern = "^[a-zA-Z0-9]+$"
+		case "numeric":
+			target.Pattern = `^[-+]?[0-9]+(?:\.[0-9]+)?$`
+		}
+	}
+	return required
+}
+
+func applySizeConstraint(s *schema, typ reflect.Type, rule, param string) {
+	switch typ.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return
+		}
+		switch rule {
+		case "gt":
+			n, rule = n+1, "min"
+		case "lt":
+			n, rule = n-1, "max"
+		case "gte":
+			rule = "min"
+		case "lte":
+			rule = "max"
+		}
+
+		switch {
+		case typ.Kind() == reflect.String && rule == "min":
+			s.MinLength = &n
+		case typ.Kind() == reflect.String:
+			s.MaxLength = &n
+		case typ.Kind() == reflect.Map && rule == "min":
+			s.MinProperties = &n
+		case typ.Kind() == reflect.Map:
+			s.MaxProperties = &n
+		case rule == "min":
+			s.MinItems = &n
+		default:
+			s.MaxItems = &n
+		}
+	default:
+		f, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return
+		}
+		switch rule {
+		case "min", "gte":
+			s.Minimum = &f
+		case "max", "lte":
+			s.Maximum = &f
+		case "gt":
+			s.ExclusiveMinimum = &f
+		case "lt":
+			s.ExclusiveMaximum = &f
+		}
+	}
+}
+
+func enumValue(typ reflect.Type, value string) any {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return strings.Trim(value, "'")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gpahal/golib/tracing"
+	"github.com/labstack/echo/v4"
+)
+
+// SpanContext has the same name as tracing.SpanContext so that the spec
+// covers schema names of types from different packages.
+type SpanContext struct {
+	TraceID string `json:"trace_id" validate:"required,len=32"`
+}
+
+type openAPITestUser struct {
+	ID      int64            `json:"id"`
+	Name    string           `json:"name" validate:"required,min=1,max=100"`
+	Email   string           `json:"email,omitempty" validate:"omitempty,email"`
+	Manager *openAPITestUser `json:"manager,omitempty"`
+}
+
+type openAPITestGetUserRequest struct {
+	ID     int64  `param:"id"`
+	Fields string `query:"fields"`
+}
+
+type openAPITestCreateUserRequest struct {
+	Name  string `json:"name" validate:"required,min=1,max=100"`
+	Email string `json:"email" validate:"omitempty,email"`
+}
+
+type openAPITestListUsersRequest struct {
+	Limit int `query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+type openAPITestListUsersResponse struct {
+	Users []openAPITestUser `json:"users"`
+}
+
+type openAPITestTraceRequest struct {
+	Local  SpanContext         `json:"local"`
+	Remote tracing.SpanContext `json:"remote"`
+}
+
+func newOpenAPITestServer() *Server {
+	e := NewWithOptions(Options{LoggerWriter: io.Discard})
+	AddRoute(e, http.MethodPost, "/traces", func(*Context, openAPITestTraceRequest) (NoContent, error) {
+		return NoContent{}, nil
+	}, RouteOptions{OperationID: "createTrace"})
+	AddRoute(e, http.MethodGet, "/traces/remote", func(*Context, struct{}) (tracing.SpanContext, error) {
+		return tracing.SpanContext{}, nil
+	}, RouteOptions{OperationID: "getRemoteTrace"})
+	AddRoute(e, http.MethodGet, "/traces/local", func(*Context, struct{}) (SpanContext, error) {
+		return SpanContext{}, nil
+	}, RouteOptions{OperationID: "getLocalTrace"})
+	AddRoute(e, http.MethodGet, "/users/:id", func(*Context, openAPITestGetUserRequest) (openAPITestUser, error) {
+		return openAPITestUser{}, nil
+	}, RouteOptions{OperationID: "getUser", Tags: []string{"users"}, ErrorStatuses: []int{http.StatusNotFound}})
+	AddRoute(e, http.MethodGet, "/users", func(*Context, openAPITestListUsersRequest) (openAPITestListUsersResponse, error) {
+		return openAPITestListUsersResponse{}, nil
+	}, RouteOptions{OperationID: "listUsers", Tags: []string{"users"}})
+	AddRoute(e, http.MethodPost, "/users", func(*Context, openAPITestCreateUserRequest) (openAPITestUser, error) {
+		return openAPITestUser{}, nil
+	}, RouteOptions{OperationID: "createUser", Tags: []string{"users"}, HandleOptions: HandleOptions{Status: http.StatusCreated}})
+	e.GET("/untyped", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	return e
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	info := OpenAPIInfo{Title: "Test", Version: "1.0.0"}
+	if err := CheckOpenAPISpec(newOpenAPITestServer(), info, "testdata/openapi.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The routes of echo are stored in a map, so generating the spec of new
+	// servers repeatedly covers different route orders.
+	want, err := OpenAPISpec(newOpenAPITestServer(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		got, err := OpenAPISpec(newOpenAPITestServer(), info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("spec differs between runs:\n%s", lineDiff(string(want), string(got)))
+		}
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	tests := []struct {
+		path       string
+		want       string
+		wantParams []string
+	}{
+		{path: "/users", want: "/users"},
+		{path: "/users/:id", want: "/users/{id}", wantParams: []string{"id"}},
+		{path: "/users/:id/posts/:postId", want: "/users/{id}/posts/{postId}", wantParams: []string{"id", "postId"}},
+		{path: "/files/*", want: "/files/{wildcard}", wantParams: []string{"*"}},
+	}
+	for _, tt := range tests {
+		got, params := openAPIPath(tt.path)
+		if got != tt.want || len(params) != len(tt.wantParams) {
+			t.Errorf("openAPIPath(%q) = %q, %v, want %q, %v", tt.path, got, params, tt.want, tt.wantParams)
+			continue
+		}
+		for i := range params {
+			if params[i] != tt.wantParams[i] {
+				t.Errorf("openAPIPath(%q) params = %v, want %v", tt.path, params, tt.wantParams)
+			}
+		}
+	}
+}
+
+func TestOpenAPISpecGroups(t *testing.T) {
+	s := NewWithOptions(Options{LoggerWriter: io.Discard})
+	api := s.Group("/api").Group("/v1")
+	AddRoute(api, http.MethodGet, "/users/:id", func(*Context, openAPITestGetUserRequest) (openAPITestUser, error) {
+		return openAPITestUser{}, nil
+	}, RouteOptions{OperationID: "getUser"})
+	other := NewWithOptions(Options{LoggerWriter: io.Discard})
+
+	spec, err := OpenAPISpec(s, OpenAPIInfo{Title: "test", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spec), `"/api/v1/users/{id}"`) {
+		t.Errorf("spec does not have the route of the group:\n%s", spec)
+	}
+	// Routes are recorded per server.
+	spec, err = OpenAPISpec(other, OpenAPIInfo{Title: "test", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(spec), "users") {
+		t.Errorf("spec of another server has the route:\n%s", spec)
+	}
+}
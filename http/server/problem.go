@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MIMEApplicationProblemJSON = "application/problem+json"
+
+	problemTypeBlank = "about:blank"
+)
+
+// Problem is an RFC 9457 problem details error. Handlers can return it to
+// control the problem details response. Extensions are serialized as
+// additional top level members.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+	// Internal is the underlying error. It is never sent to clients.
+	Internal error
+}
+
+// NewProblem returns a Problem with the given status and detail, and a title
+// defaulting to the status text.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Status: status, Title: http.StatusText(status), Detail: detail}
+}
+
+func (p *Problem) Error() string {
+	msg := p.Title
+	if msg == "" {
+		msg = http.StatusText(p.Status)
+	}
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	if p.Internal != nil {
+		msg += ": " + p.Internal.Error()
+	}
+	return msg
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Internal
+}
+
+// WithExtension sets the extension member key and returns p.
+func (p *Problem) WithExtension(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]any)
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+// SetInternal sets the internal error and returns p.
+func (p *Problem) SetInternal(err error) *Problem {
+	p.Internal = err
+	return p
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+
+	typ := p.Type
+	if typ == "" {
+		typ = problemTypeBlank
+	}
+	m["type"] = typ
+	title := p.Title
+	if title == "" {
+		title = http.StatusText(p.Status)
+	}
+	m["title"] = title
+	m["status"] = p.Status
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// status returns the status of p, defaulting to 500.
+func (p *Problem) status() int {
+	if p.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return p.Status
+}
+
+func (p *Problem) httpError() *echo.HTTPError {
+	message := p.Detail
+	if message == "" {
+		message = p.Title
+	}
+	if message == "" {
+		message = http.StatusText(p.status())
+	}
+	return &echo.HTTPError{Code: p.status(), Message: message, Internal: p}
+}
+
+// problemFromHttpError converts he to a Problem, using the Problem it wraps
+// if there is one.
+func problemFromHttpError(he *echo.HTTPError) *Problem {
+	if p, ok := he.Internal.(*Problem); ok {
+		if p.Status != 0 {
+			return p
+		}
+		// The problem is copied as handlers may reuse it.
+		defaulted := *p
+		defaulted.Status = p.status()
+		return &defaulted
+	}
+
+	p := &Problem{Status: he.Code, Title: http.StatusText(he.Code), Internal: he.Internal}
+	switch m := he.Message.(type) {
+	case string:
+		if m != p.Title {
+			p.Detail = m
+		}
+	case error:
+		p.Detail = m.Error()
+	case nil:
+	default:
+		p.Extensions = map[string]any{"error": m}
+	}
+	return p
+}
+
+// acceptsProblemJSON reports whether a problem+json response is acceptable
+// given the Accept header. Clients asking for plain JSON with a higher
+// preference than problem+json get the legacy error shape.
+func acceptsProblemJSON(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	problemQ, jsonQ, wildcardQ := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(qs, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch mediaType {
+		case MIMEApplicationProblemJSON:
+			problemQ = max(problemQ, q)
+		case web.MIMEApplicationJSON:
+			jsonQ = max(jsonQ, q)
+		case "application/*", "*/*":
+			wildcardQ = max(wildcardQ, q)
+		}
+	}
+	if problemQ < 0 {
+		problemQ = wildcardQ
+	}
+
+	if problemQ <= 0 {
+		return false
+	}
+	return jsonQ < 0 || problemQ >= jsonQ
+}
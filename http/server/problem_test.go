@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+func TestProblemResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		problem     *Problem
+		accept      string
+		wantStatus  int
+		wantTitle   string
+		wantProblem bool
+	}{
+		{
+			name:        "problem",
+			problem:     NewProblem(http.StatusConflict, "taken"),
+			wantStatus:  http.StatusConflict,
+			wantTitle:   http.StatusText(http.StatusConflict),
+			wantProblem: true,
+		},
+		{
+			name:        "problem without status",
+			problem:     &Problem{Detail: "failed"},
+			wantStatus:  http.StatusInternalServerError,
+			wantTitle:   http.StatusText(http.StatusInternalServerError),
+			wantProblem: true,
+		},
+		{
+			name:       "legacy problem without status",
+			problem:    &Problem{Detail: "failed"},
+			accept:     web.MIMEApplicationJSON,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewWithOptions(Options{LoggerWriter: io.Discard, ProblemDetails: true})
+			e.Match([]string{http.MethodGet, http.MethodHead}, "/", func(c echo.Context) error {
+				return tt.problem
+			})
+
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				req := httptest.NewRequest(method, "/", nil)
+				if tt.accept != "" {
+					req.Header.Set(web.HeaderAccept, tt.accept)
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				if rec.Code != tt.wantStatus {
+					t.Errorf("%s status = %d, want %d", method, rec.Code, tt.wantStatus)
+				}
+				if method == http.MethodHead || !tt.wantProblem {
+					continue
+				}
+
+				if got := rec.Header().Get(web.HeaderContentType); got != MIMEApplicationProblemJSON {
+					t.Errorf("Content-Type = %q, want %q", got, MIMEApplicationProblemJSON)
+				}
+				var body struct {
+					Title  string `json:"title"`
+					Status int    `json:"status"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Status != tt.wantStatus || body.Title != tt.wantTitle {
+					t.Errorf("body = %+v, want status %d and title %q", body, tt.wantStatus, tt.wantTitle)
+				}
+			}
+		})
+	}
+}
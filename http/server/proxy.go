@@ -0,0 +1,200 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	web "github.com/gpahal/golib/http"
+)
+
+var (
+	defaultClientIPHeaders = []string{web.HeaderForwarded, web.HeaderXForwardedFor, web.HeaderXRealIP}
+)
+
+// clientInfo is the client of a request as seen by the outermost trusted
+// proxy, or the peer of the connection without trusted proxies.
+type clientInfo struct {
+	ip     string
+	scheme string
+	host   string
+}
+
+type proxyResolver struct {
+	trusted []netip.Prefix
+	headers []string
+}
+
+// newProxyResolver parses the trusted proxies, which are IPs or CIDRs. It
+// panics if one is invalid, like route registration does.
+func newProxyResolver(trustedProxies []string, headers []string) *proxyResolver {
+	r := &proxyResolver{headers: headers}
+	if len(r.headers) == 0 {
+		r.headers = defaultClientIPHeaders
+	}
+	for _, proxy := range trustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				panic("server: invalid trusted proxy " + proxy + ": " + err.Error())
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r
+}
+
+func (r *proxyResolver) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *proxyResolver) clientIP(req *http.Request) string {
+	return r.resolve(req).ip
+}
+
+func (r *proxyResolver) resolve(req *http.Request) clientInfo {
+	info := clientInfo{ip: remoteIP(req.RemoteAddr), scheme: "http", host: req.Host}
+	if req.TLS != nil {
+		info.scheme = "https"
+	}
+	if !r.isTrusted(info.ip) {
+		return info
+	}
+
+	for _, header := range r.headers {
+		var hops []forwardedElement
+		switch http.CanonicalHeaderKey(header) {
+		case web.HeaderForwarded:
+			hops = parseForwarded(req.Header.Values(web.HeaderForwarded))
+		case web.HeaderXForwardedFor:
+			hops = parseXForwardedFor(req)
+		default:
+			if ip := strings.TrimSpace(req.Header.Get(header)); ip != "" {
+				hops = []forwardedElement{{forIP: ip}}
+			}
+		}
+		if len(hops) == 0 {
+			continue
+		}
+
+		// Proxies append the peer they received the request from, so the
+		// client is the rightmost hop that is not a trusted proxy.
+		hop := hops[0]
+		for i := len(hops) - 1; i >= 0; i-- {
+			if !r.isTrusted(hops[i].forIP) {
+				hop = hops[i]
+				break
+			}
+		}
+		if ip, err := netip.ParseAddr(hop.forIP); err == nil {
+			info.ip = ip.Unmap().String()
+		}
+		if hop.proto == "http" || hop.proto == "https" {
+			info.scheme = hop.proto
+		}
+		if hop.host != "" {
+			info.host = hop.host
+		}
+		return info
+	}
+	return info
+}
+
+type forwardedElement struct {
+	forIP string
+	proto string
+	host  string
+}
+
+// parseForwarded parses RFC 7239 Forwarded headers. Obfuscated and unknown
+// nodes are kept with an invalid IP so that they are never trusted.
+func parseForwarded(values []string) []forwardedElement {
+	var elements []forwardedElement
+	for _, value := range values {
+		for _, elementStr := range strings.Split(value, ",") {
+			var element forwardedElement
+			for _, pair := range strings.Split(elementStr, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(v, `"`)
+				switch strings.ToLower(k) {
+				case "for":
+					element.forIP = forwardedNodeIP(v)
+				case "proto":
+					element.proto = strings.ToLower(v)
+				case "host":
+					element.host = v
+				}
+			}
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// forwardedNodeIP returns the IP of a Forwarded node, e.g. 192.0.2.1,
+// "192.0.2.1:8080" or "[2001:db8::1]:8080".
+func forwardedNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i > 0 {
+			return node[1:i]
+		}
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// parseXForwardedFor parses X-Forwarded-For headers, taking the scheme and
+// host from the last X-Forwarded-Proto and X-Forwarded-Host values. Unlike
+// the first values, which clients can set, the last ones are set by the
+// nearest proxy, which is trusted.
+func parseXForwardedFor(req *http.Request) []forwardedElement {
+	proto := strings.ToLower(lastHeaderValue(req.Header.Values(web.HeaderXForwardedProto)))
+	host := lastHeaderValue(req.Header.Values(web.HeaderXForwardedHost))
+
+	var elements []forwardedElement
+	for _, value := range req.Header.Values(web.HeaderXForwardedFor) {
+		for _, ip := range strings.Split(value, ",") {
+			elements = append(elements, forwardedElement{forIP: forwardedNodeIP(strings.TrimSpace(ip)), proto: proto, host: host})
+		}
+	}
+	return elements
+}
+
+// lastHeaderValue returns the last value of comma separated header values.
+func lastHeaderValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	value := values[len(values)-1]
+	if i := strings.LastIndexByte(value, ','); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+func remoteIP(remoteAddr string) string {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyResolverResolve(t *testing.T) {
+	r := newProxyResolver([]string{"10.0.0.0/8"}, nil)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       clientInfo
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "192.0.2.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"evil.example.com"},
+			},
+			want: clientInfo{ip: "192.0.2.1", scheme: "http", host: "example.com"},
+		},
+		{
+			name:       "x-forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"app.example.com"},
+			},
+			want: clientInfo{ip: "198.51.100.1", scheme: "https", host: "app.example.com"},
+		},
+		{
+			name:       "x-forwarded spoofed by client",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"203.0.113.1, 198.51.100.1"},
+				"X-Forwarded-Proto": {"http, https"},
+				"X-Forwarded-Host":  {"evil.example.com", "app.example.com"},
+			},
+			want: clientInfo{ip: "198.51.100.1", scheme: "https", host: "app.example.com"},
+		},
+		{
+			name:       "x-forwarded through trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"203.0.113.1", "198.51.100.1, 10.0.0.2"},
+				"X-Forwarded-Proto": {"http,https"},
+				"X-Forwarded-Host":  {"evil.example.com,app.example.com"},
+			},
+			want: clientInfo{ip: "198.51.100.1", scheme: "https", host: "app.example.com"},
+		},
+		{
+			name:       "x-forwarded invalid proto",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"https, ftp"},
+			},
+			want: clientInfo{ip: "198.51.100.1", scheme: "http", host: "example.com"},
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"Forwarded": {`for=203.0.113.1;proto=http;host=evil.example.com, for="198.51.100.1:4321";proto=https;host=app.example.com`},
+			},
+			want: clientInfo{ip: "198.51.100.1", scheme: "https", host: "app.example.com"},
+		},
+		{
+			name:       "forwarded ipv6",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"Forwarded": {`for="[2001:db8::1]:4321"`},
+			},
+			want: clientInfo{ip: "2001:db8::1", scheme: "http", host: "example.com"},
+		},
+		{
+			name:       "x-real-ip",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"X-Real-Ip": {"198.51.100.1"},
+			},
+			want: clientInfo{ip: "198.51.100.1", scheme: "http", host: "example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, values := range tt.headers {
+				for _, v := range values {
+					req.Header.Add(k, v)
+				}
+			}
+			if got := r.resolve(req); got != tt.want {
+				t.Errorf("resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewProxyResolverInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("newProxyResolver did not panic")
+		}
+	}()
+	newProxyResolver([]string{"not-an-ip"}, nil)
+}
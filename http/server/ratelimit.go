@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/pkg/errors"
+)
+
+const (
+	rateLimitStoreShards = 64
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// RateLimitAlgorithm is the algorithm used to count requests.
+type RateLimitAlgorithm int
+
+const (
+	// RateLimitTokenBucket allows bursts of up to Burst requests, refilling
+	// Limit tokens per Window.
+	RateLimitTokenBucket RateLimitAlgorithm = iota
+	// RateLimitSlidingWindow allows Limit requests in any Window, weighting
+	// the count of the previous fixed window by its overlap with the
+	// sliding window.
+	RateLimitSlidingWindow
+)
+
+// RateLimitPolicy is a limit of Limit requests per Window.
+type RateLimitPolicy struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+	// Burst is the bucket capacity of RateLimitTokenBucket, defaulting to
+	// Limit.
+	Burst int
+}
+
+// RateLimitResult is the result of taking a request from a rate limit.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the limit is fully available again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed if this one
+	// was not.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore stores rate limit state. Implementations must apply policy
+// atomically for each key.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc returns the key requests are limited by. Requests with an
+// empty key are not limited.
+type RateLimitKeyFunc func(c echo.Context) (string, error)
+
+// RateLimitByIP limits requests by client IP, see Context.ClientIP, which
+// only trusts the forwarding headers of Options.TrustedProxies. Outside of a
+// server created with NewWithOptions, it falls back to echo's RealIP, which
+// trusts the X-Forwarded-For and X-Real-IP headers of any client unless
+// echo's IPExtractor is set.
+func RateLimitByIP() RateLimitKeyFunc {
+	return func(c echo.Context) (string, error) {
+		if sctx, ok := c.(*Context); ok {
+			return "ip:" + sctx.ClientIP, nil
+		}
+		return "ip:" + c.RealIP(), nil
+	}
+}
+
+// RateLimitByHeader limits requests by the value of header, e.g. an API key
+// header. Requests without the header are not limited.
+func RateLimitByHeader(header string) RateLimitKeyFunc {
+	return func(c echo.Context) (string, error) {
+		value := c.Request().Header.Get(header)
+		if value == "" {
+			return "", nil
+		}
+		return header + ":" + value, nil
+	}
+}
+
+// RateLimitOptions configures a rate limit.
+type RateLimitOptions struct {
+	RateLimitPolicy
+	// Name namespaces the keys of the limit in Store, so that multiple
+	// limits can share a store.
+	Name string
+	// Key defaults to RateLimitByIP.
+	Key RateLimitKeyFunc
+	// Store defaults to a new MemoryRateLimitStore.
+	Store   RateLimitStore
+	Skipper middleware.Skipper
+}
+
+// RateLimit returns a middleware limiting requests. Limited requests fail
+// with a 429 error, and all responses carry RateLimit-* headers.
+func RateLimit(opts RateLimitOptions) echo.MiddlewareFunc {
+	if opts.Limit <= 0 || opts.Window <= 0 {
+		panic("server: rate limit: limit and window must be positive")
+	}
+	if opts.Key == nil {
+		opts.Key = RateLimitByIP()
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryRateLimitStore()
+	}
+	if opts.Skipper == nil {
+		opts.Skipper = middleware.DefaultSkipper
+	}
+	policyHeader := strconv.Itoa(opts.Limit) + ";w=" + strconv.FormatInt(int64(math.Ceil(opts.Window.Seconds())), 10)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if opts.Skipper(c) {
+				return next(c)
+			}
+
+			key, err := opts.Key(c)
+			if err != nil {
+				return err
+			}
+			if key == "" {
+				return next(c)
+			}
+			if opts.Name != "" {
+				key = opts.Name + ":" + key
+			}
+
+			result, err := opts.Store.Take(c.Request().Context(), key, opts.RateLimitPolicy, time.Now())
+			if err != nil {
+				return errors.Wrap(err, "rate limit store")
+			}
+
+			header := c.Response().Header()
+			header.Set(web.HeaderRateLimitPolicy, policyHeader)
+			header.Set(web.HeaderRateLimitLimit, strconv.Itoa(result.Limit))
+			header.Set(web.HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+			header.Set(web.HeaderRateLimitReset, ceilSeconds(result.Reset))
+			if !result.Allowed {
+				header.Set(web.HeaderRetryAfter, ceilSeconds(result.RetryAfter))
+				return NewHttpErrorWithInternal(http.StatusTooManyRequests, ErrRateLimited.Error(), ErrRateLimited)
+			}
+			return next(c)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// MemoryRateLimitStore is an in-memory RateLimitStore. Keys are spread over
+// shards to reduce lock contention, and expired keys are swept lazily.
+type MemoryRateLimitStore struct {
+	shards [rateLimitStoreShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+}
+
+type rateLimitEntry struct {
+	// tokens and last are the state of RateLimitTokenBucket.
+	tokens float64
+	last   time.Time
+	// windowStart, count and prevCount are the state of
+	// RateLimitSlidingWindow.
+	windowStart time.Time
+	count       int
+	prevCount   int
+
+	expiresAt time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitStoreShards]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if now.Sub(shard.lastSweep) >= policy.Window {
+		for k, entry := range shard.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(shard.entries, k)
+			}
+		}
+		shard.lastSweep = now
+	}
+
+	entry, ok := shard.entries[key]
+	if !ok {
+		entry = &rateLimitEntry{}
+		shard.entries[key] = entry
+	}
+
+	if policy.Algorithm == RateLimitSlidingWindow {
+		return entry.takeSlidingWindow(policy, now), nil
+	}
+	return entry.takeTokenBucket(policy, now), nil
+}
+
+func (e *rateLimitEntry) takeTokenBucket(policy RateLimitPolicy, now time.Time) RateLimitResult {
+	capacity := float64(policy.Burst)
+	if capacity <= 0 {
+		capacity = float64(policy.Limit)
+	}
+	// perToken is the time to refill a single token, in nanoseconds. It is a
+	// float so that windows shorter than Limit nanoseconds don't round it to
+	// zero.
+	perToken := float64(policy.Window) / float64(policy.Limit)
+
+	if e.last.IsZero() {
+		e.tokens = capacity
+	} else {
+		e.tokens = math.Min(capacity, e.tokens+float64(now.Sub(e.last))/perToken)
+	}
+	e.last = now
+
+	result := RateLimitResult{Limit: int(capacity)}
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - e.tokens) * perToken)
+	}
+	result.Remaining = int(e.tokens)
+	result.Reset = time.Duration((capacity - e.tokens) * perToken)
+	e.expiresAt = now.Add(result.Reset)
+	return result
+}
+
+func (e *rateLimitEntry) takeSlidingWindow(policy RateLimitPolicy, now time.Time) RateLimitResult {
+	window := policy.Window
+	limit := float64(policy.Limit)
+
+	if e.windowStart.IsZero() {
+		e.windowStart = now.Truncate(window)
+	}
+	if elapsedWindows := now.Sub(e.windowStart) / window; elapsedWindows >= 1 {
+		if elapsedWindows == 1 {
+			e.prevCount = e.count
+		} else {
+			e.prevCount = 0
+		}
+		e.count = 0
+		e.windowStart = e.windowStart.Add(elapsedWindows * window)
+	}
+
+	elapsed := now.Sub(e.windowStart)
+	prevWeight := 1 - float64(elapsed)/float64(window)
+	estimate := float64(e.prevCount)*prevWeight + float64(e.count)
+
+	result := RateLimitResult{Limit: policy.Limit}
+	if estimate+1 <= limit {
+		e.count++
+		estimate++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = e.slidingWindowRetryAfter(window, limit, elapsed)
+	}
+	result.Remaining = int(math.Max(0, math.Floor(limit-estimate)))
+	// The previous window stops counting at the end of the current one, and
+	// the current one at the end of the next one.
+	result.Reset = window - elapsed
+	if e.count > 0 {
+		result.Reset += window
+	}
+	e.expiresAt = e.windowStart.Add(2 * window)
+	return result
+}
+
+// slidingWindowRetryAfter returns the time until the estimated count drops
+// to limit-1 so that one more request fits.
+func (e *rateLimitEntry) slidingWindowRetryAfter(window time.Duration, limit float64, elapsed time.Duration) time.Duration {
+	if float64(e.count) < limit && e.prevCount > 0 {
+		// prevCount*(1-t/window) + count <= limit-1
+		t := float64(window) * (1 - (limit-1-float64(e.count))/float64(e.prevCount))
+		return time.Duration(t) - elapsed
+	}
+	// The current window becomes the previous one:
+	// count*(1-t/window) <= limit-1
+	t := float64(window) * (1 - (limit-1)/float64(e.count))
+	return window - elapsed + time.Duration(t)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMemoryRateLimitStoreTokenBucket(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Algorithm: RateLimitTokenBucket, Limit: 2, Window: time.Second}
+	now := time.Unix(1000, 0)
+	take := func(now time.Time) RateLimitResult {
+		t.Helper()
+		result, err := store.Take(context.Background(), "k", policy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	for i := 0; i < 2; i++ {
+		if result := take(now); !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i, result, 1-i)
+		}
+	}
+	result := take(now)
+	if result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("request over the limit = %+v, want limited with a 500ms retry after", result)
+	}
+	if result := take(now.Add(500 * time.Millisecond)); !result.Allowed {
+		t.Fatalf("request after refill = %+v, want allowed", result)
+	}
+}
+
+func TestMemoryRateLimitStoreTokenBucketShortWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	// The window is shorter in nanoseconds than the limit.
+	policy := RateLimitPolicy{Algorithm: RateLimitTokenBucket, Limit: 1000, Window: 100 * time.Nanosecond, Burst: 1}
+	now := time.Unix(1000, 0)
+
+	if result, _ := store.Take(context.Background(), "k", policy, now); !result.Allowed {
+		t.Fatalf("first request = %+v, want allowed", result)
+	}
+	result, _ := store.Take(context.Background(), "k", policy, now)
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("second request = %+v, want limited with 0 remaining", result)
+	}
+	if result, _ := store.Take(context.Background(), "k", policy, now.Add(time.Nanosecond)); !result.Allowed {
+		t.Fatalf("request after refill = %+v, want allowed", result)
+	}
+}
+
+func TestMemoryRateLimitStoreSlidingWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Algorithm: RateLimitSlidingWindow, Limit: 2, Window: time.Minute}
+	start := time.Unix(6000, 0)
+	take := func(now time.Time) RateLimitResult {
+		t.Helper()
+		result, err := store.Take(context.Background(), "k", policy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	take(start)
+	take(start.Add(time.Second))
+	if result := take(start.Add(2 * time.Second)); result.Allowed {
+		t.Fatalf("request over the limit = %+v, want limited", result)
+	}
+	// Halfway through the next window, the previous window counts for 1.
+	if result := take(start.Add(90 * time.Second)); !result.Allowed {
+		t.Fatalf("request in the next window = %+v, want allowed", result)
+	}
+	if result := take(start.Add(91 * time.Second)); result.Allowed {
+		t.Fatalf("request over the weighted limit = %+v, want limited", result)
+	}
+	if result := take(start.Add(3 * time.Minute)); !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("request after two windows = %+v, want allowed with 1 remaining", result)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	e := NewWithOptions(Options{
+		LoggerWriter:   io.Discard,
+		TrustedProxies: []string{"10.0.0.0/8"},
+		RateLimit:      &RateLimitOptions{RateLimitPolicy: RateLimitPolicy{Limit: 1, Window: time.Minute}},
+	})
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	request := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set(web.HeaderXForwardedFor, forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("192.0.2.1:1234", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("first request status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	for k, want := range map[string]string{
+		web.HeaderRateLimitPolicy:    "1;w=60",
+		web.HeaderRateLimitLimit:     "1",
+		web.HeaderRateLimitRemaining: "0",
+		web.HeaderRateLimitReset:     "60",
+	} {
+		if got := rec.Header().Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+
+	// Spoofed forwarding headers of untrusted clients are ignored.
+	rec = request("192.0.2.1:1234", "198.51.100.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed request status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get(web.HeaderRetryAfter); got != "60" {
+		t.Errorf("Retry-After = %q, want %q", got, "60")
+	}
+
+	// Clients behind trusted proxies are limited separately.
+	if rec := request("10.0.0.1:1234", "198.51.100.1"); rec.Code != http.StatusNoContent {
+		t.Fatalf("proxied request status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := request("10.0.0.2:1234", "198.51.100.1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second proxied request status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitInvalidPolicy(t *testing.T) {
+	for _, policy := range []RateLimitPolicy{{Limit: 0, Window: time.Second}, {Limit: 1, Window: 0}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RateLimit(%+v) did not panic", policy)
+				}
+			}()
+			RateLimit(RateLimitOptions{RateLimitPolicy: policy})
+		}()
+	}
+}
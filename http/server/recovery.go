@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PanicError is the internal error of the 500 error returned by the recovery
+// middleware when a handler panics.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// PanicReporter reports recovered panics, for example to an error tracking
+// service.
+type PanicReporter interface {
+	ReportPanic(c echo.Context, err *PanicError)
+}
+
+type PanicReporterFunc func(c echo.Context, err *PanicError)
+
+func (prf PanicReporterFunc) ReportPanic(c echo.Context, err *PanicError) {
+	prf(c, err)
+}
+
+func newRecoveryMiddleware(opts Options) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (returnErr error) {
+			defer func() {
+				if r := recover(); r != nil {
+					if r == http.ErrAbortHandler {
+						panic(r)
+					}
+
+					panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+					logger := opts.Logger
+					if sctx, ok := c.(*Context); ok {
+						logger = sctx.ServerLogger
+					} else {
+						logger = newContextLogger(c, logger)
+					}
+					logger.Error().Err(panicErr).Str("stack", string(panicErr.Stack)).Msg("recovery handler")
+
+					if opts.PanicReporter != nil {
+						opts.PanicReporter.ReportPanic(c, panicErr)
+					}
+					returnErr = NewHttpErrorWithInternal(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), panicErr)
+				}
+			}()
+			return next(c)
+		}
+	}
+}
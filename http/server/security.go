@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	web "github.com/gpahal/golib/http"
+	"github.com/labstack/echo/v4"
+)
+
+// CSPNonce is a CSP source replaced with a nonce generated for each request,
+// e.g. NewCSP().Directive("script-src", CSPSelf, CSPNonce). The nonce is
+// available to handlers as Context.CSPNonce.
+const CSPNonce = "'nonce'"
+
+// Common CSP sources.
+const (
+	CSPNone          = "'none'"
+	CSPSelf          = "'self'"
+	CSPUnsafeInline  = "'unsafe-inline'"
+	CSPUnsafeEval    = "'unsafe-eval'"
+	CSPStrictDynamic = "'strict-dynamic'"
+)
+
+// CSP is a Content-Security-Policy builder. Directives are written in the
+// order they were added.
+type CSP struct {
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+func NewCSP() *CSP {
+	return &CSP{}
+}
+
+// Directive adds the sources to the directive name, e.g. "default-src". A
+// directive without sources, like "upgrade-insecure-requests", is written
+// as is.
+func (p *CSP) Directive(name string, sources ...string) *CSP {
+	for i := range p.directives {
+		if p.directives[i].name == name {
+			p.directives[i].sources = append(p.directives[i].sources, sources...)
+			return p
+		}
+	}
+	p.directives = append(p.directives, cspDirective{name: name, sources: sources})
+	return p
+}
+
+// ReportURI adds the report-uri directive.
+func (p *CSP) ReportURI(uri string) *CSP {
+	return p.Directive("report-uri", uri)
+}
+
+// ReportTo adds the report-to directive with the name of a reporting
+// endpoint group.
+func (p *CSP) ReportTo(group string) *CSP {
+	return p.Directive("report-to", group)
+}
+
+// HasNonce reports whether the policy uses CSPNonce.
+func (p *CSP) HasNonce() bool {
+	for _, d := range p.directives {
+		for _, source := range d.sources {
+			if source == CSPNonce {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String returns the policy with CSPNonce replaced by nonce.
+func (p *CSP) String(nonce string) string {
+	var sb strings.Builder
+	for i, d := range p.directives {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(d.name)
+		for _, source := range d.sources {
+			sb.WriteByte(' ')
+			if source == CSPNonce {
+				sb.WriteString("'nonce-" + nonce + "'")
+			} else {
+				sb.WriteString(source)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// SecurityHeaders are the security related response headers set on every
+// response. Empty values are not set.
+type SecurityHeaders struct {
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header,
+	// which is not set if it is zero.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	ContentTypeNosniff        bool
+	XFrameOptions             string
+	XXSSProtection            string
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginResourcePolicy string
+	CrossOriginEmbedderPolicy string
+
+	CSP *CSP
+	// CSPReportOnly sends CSP as Content-Security-Policy-Report-Only so that
+	// violations are reported but not enforced.
+	CSPReportOnly bool
+}
+
+// APISecurityHeaders returns security headers for JSON APIs, which never
+// render documents and so can deny everything.
+func APISecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		HSTSMaxAge:                365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		ContentTypeNosniff:        true,
+		XFrameOptions:             "DENY",
+		XXSSProtection:            "0",
+		ReferrerPolicy:            "no-referrer",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+		CSP:                       NewCSP().Directive("default-src", CSPNone).Directive("frame-ancestors", CSPNone),
+	}
+}
+
+// WebAppSecurityHeaders returns security headers for server rendered web
+// apps. Scripts must be loaded from the same origin or carry the request
+// nonce, see Context.CSPNonce.
+func WebAppSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		HSTSMaxAge:                365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		ContentTypeNosniff:        true,
+		XFrameOptions:             "SAMEORIGIN",
+		XXSSProtection:            "0",
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+		CSP: NewCSP().
+			Directive("default-src", CSPSelf).
+			Directive("script-src", CSPSelf, CSPNonce, CSPStrictDynamic).
+			Directive("style-src", CSPSelf, CSPNonce).
+			Directive("img-src", CSPSelf, "data:").
+			Directive("object-src", CSPNone).
+			Directive("base-uri", CSPSelf).
+			Directive("form-action", CSPSelf).
+			Directive("frame-ancestors", CSPSelf),
+	}
+}
+
+func newSecurityHeadersMiddleware(h SecurityHeaders) echo.MiddlewareFunc {
+	static := make(map[string]string)
+	if h.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(h.HSTSMaxAge/time.Second), 10)
+		if h.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if h.HSTSPreload {
+			hsts += "; preload"
+		}
+		static[web.HeaderStrictTransportSecurity] = hsts
+	}
+	if h.ContentTypeNosniff {
+		static[web.HeaderXContentTypeOptions] = "nosniff"
+	}
+	for header, value := range map[string]string{
+		web.HeaderXFrameOptions:             h.XFrameOptions,
+		web.HeaderXXSSProtection:            h.XXSSProtection,
+		web.HeaderReferrerPolicy:            h.ReferrerPolicy,
+		web.HeaderPermissionsPolicy:         h.PermissionsPolicy,
+		web.HeaderCrossOriginOpenerPolicy:   h.CrossOriginOpenerPolicy,
+		web.HeaderCrossOriginResourcePolicy: h.CrossOriginResourcePolicy,
+		web.HeaderCrossOriginEmbedderPolicy: h.CrossOriginEmbedderPolicy,
+	} {
+		if value != "" {
+			static[header] = value
+		}
+	}
+
+	cspHeader := web.HeaderContentSecurityPolicy
+	if h.CSPReportOnly {
+		cspHeader = web.HeaderContentSecurityPolicyReportOnly
+	}
+	var csp string
+	withNonce := false
+	if h.CSP != nil {
+		withNonce = h.CSP.HasNonce()
+		if !withNonce {
+			csp = h.CSP.String("")
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Response().Header()
+			for k, v := range static {
+				header.Set(k, v)
+			}
+
+			if withNonce {
+				nonce, err := newCSPNonce()
+				if err != nil {
+					return err
+				}
+				GetContext(c).CSPNonce = nonce
+				header.Set(cspHeader, h.CSP.String(nonce))
+			} else if csp != "" {
+				header.Set(cspHeader, csp)
+			}
+			return next(c)
+		}
+	}
+}
+
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
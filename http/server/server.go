@@ -2,15 +2,22 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/dustin/go-humanize"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
+	"github.com/gpahal/golib/metrics"
+	"github.com/gpahal/golib/tracing"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
@@ -20,18 +27,178 @@ import (
 type OnHttpErrorHandler func(c echo.Context, err *echo.HTTPError)
 
 type Options struct {
-	Validator    *validator.Validate
-	Config       any
-	LoggerWriter io.Writer
-	Logger       *zerolog.Logger
-	OnHttpError  OnHttpErrorHandler
+	Validator *validator.Validate
+	// UniversalTranslator translates validation error messages to the
+	// language negotiated from the Accept-Language header of each request.
+	// Translations must be registered with the validator, e.g. with
+	// github.com/go-playground/validator/v10/translations/en.
+	UniversalTranslator *ut.UniversalTranslator
+	Config              any
+	LoggerWriter        io.Writer
+	Logger              *zerolog.Logger
+	OnHttpError         OnHttpErrorHandler
+	// ProblemDetails makes the error handler respond with RFC 9457
+	// application/problem+json bodies. Clients that prefer application/json
+	// in their Accept header still get the {"error": ...} shape.
+	ProblemDetails bool
+	// ErrorMapper maps errors returned by typed handlers created with Handle.
+	ErrorMapper ErrorMapper
+	// Services are made available to handlers through Service. They are
+	// closed by StartWithOptions after the server has shut down.
+	Services *Services
+	// Tracer starts a server span for every request. Incoming W3C trace
+	// context is extracted even if Tracer is nil so that logs stay
+	// correlated with the caller. Outgoing requests of clients with a
+	// tracer, see client.Options.Tracer, continue the extracted trace.
+	Tracer tracing.Tracer
+	// Metrics records request counts, latencies and in-flight requests. If
+	// it also implements metrics.Exposer, its metrics are served at
+	// MetricsPath, which defaults to /metrics.
+	Metrics     metrics.Registry
+	MetricsPath string
+
+	// Health serves its health checks at /healthz, /readyz and /livez.
+	// Readiness fails while StartWithOptions shuts the server down.
+	Health *Health
+
+	// OpenAPI serves an OpenAPI 3.1 spec of the routes registered with
+	// AddRoute.
+	OpenAPI *OpenAPIOptions
+
+	// TrustedProxies are the IPs and CIDRs of proxies whose forwarding
+	// headers are trusted to resolve the client IP, scheme and host of
+	// requests, see Context.ClientIP. ClientIPHeaders are the forwarding
+	// headers in order of precedence, defaulting to Forwarded,
+	// X-Forwarded-For (with X-Forwarded-Proto and X-Forwarded-Host) and
+	// X-Real-IP. Without trusted proxies, forwarding headers are ignored.
+	TrustedProxies  []string
+	ClientIPHeaders []string
+
+	// SecurityHeaders are set on every response, see APISecurityHeaders and
+	// WebAppSecurityHeaders for presets.
+	SecurityHeaders *SecurityHeaders
+
+	// CORS is the cross-origin resource sharing policy of the server.
+	// RouteCORS overrides it for routes keyed by their path as registered, a
+	// nil policy disabling CORS for the route.
+	CORS      *CORSPolicy
+	RouteCORS map[string]*CORSPolicy
+
+	// RateLimit limits requests to all routes, see RateLimit for limits of
+	// single routes.
+	RateLimit *RateLimitOptions
+
+	// Sessions makes a session available to handlers as Context.Session.
+	Sessions *SessionOptions
+
+	// Authenticators are tried in order to set Context.Principal, see
+	// RequireAuth and RequireScopes.
+	Authenticators []Authenticator
+
+	// CSRF protects unsafe requests against cross-site request forgery.
+	CSRF *CSRFOptions
+
+	// Idempotency replays the stored responses of requests retried with the
+	// same Idempotency-Key header.
+	Idempotency *IdempotencyOptions
+
+	// Compression compresses responses with the encoding negotiated from
+	// the Accept-Encoding header.
+	Compression *CompressionOptions
+
+	// TrailingSlash controls trailing slash handling before routing. Paths
+	// are rewritten in place unless TrailingSlashRedirectCode is set.
+	TrailingSlash             TrailingSlash
+	TrailingSlashRedirectCode int
+
+	// RequestID, RequestLogger and Recovery replace the default middleware of
+	// their stage. The Disable* options remove the stage altogether.
+	RequestID            echo.MiddlewareFunc
+	DisableRequestID     bool
+	RequestLogger        echo.MiddlewareFunc
+	DisableRequestLogger bool
+	Recovery             echo.MiddlewareFunc
+	DisableRecovery      bool
+	// PanicReporter is notified of panics recovered by the default recovery
+	// middleware.
+	PanicReporter PanicReporter
+
+	// Timeout is the request timeout, defaulting to 30s. RouteTimeouts
+	// overrides it for routes keyed by their path as registered, e.g.
+	// "/users/:id". A negative duration disables the timeout, which is
+	// required for streaming and websocket routes.
+	Timeout        time.Duration
+	RouteTimeouts  map[string]time.Duration
+	TimeoutSkipper middleware.Skipper
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout configure
+	// the http server, defaulting to 10s, 60s, 60s and 120s. A negative
+	// duration disables the timeout. WriteTimeout also bounds streaming
+	// responses, so it must be disabled or raised for long-lived streams.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// MaxHeaderBytes limits the size of request headers, defaulting to 1MB.
+	MaxHeaderBytes int
+
+	// BodyLimit is the maximum request body size in bytes, defaulting to 4MB.
+	// RouteBodyLimits overrides it for routes keyed by their path as
+	// registered. A negative limit disables the limit. Larger bodies are
+	// rejected with a 413.
+	BodyLimit       int64
+	RouteBodyLimits map[string]int64
+
+	// Middleware are custom middleware inserted at their position in the
+	// stack, in order.
+	Middleware []Middleware
+}
+
+// Server is a server created with NewWithOptions. Routes and middleware are
+// registered on it like on the echo instance it embeds, and it holds the
+// state needed to start and stop it.
+type Server struct {
+	*echo.Echo
+	services     *Services
+	shuttingDown atomic.Bool
+
+	// routeMetas maps routes registered with AddRoute to their metadata.
+	routeMetasMu sync.Mutex
+	routeMetas   map[*echo.Route]routeMeta
+}
+
+// Group creates a group of routes with the path prefix and middleware.
+func (s *Server) Group(prefix string, m ...echo.MiddlewareFunc) *Group {
+	return &Group{echoGroup: s.Echo.Group(prefix, m...), server: s}
 }
 
-func New() *echo.Echo {
+func (s *Server) routeServer() *Server {
+	return s
+}
+
+// Group is a group of routes of a Server. Routes and middleware are
+// registered on it like on the echo group it embeds.
+type Group struct {
+	*echoGroup
+	server *Server
+}
+
+type echoGroup = echo.Group
+
+// Group creates a subgroup of routes with the path prefix and middleware.
+func (g *Group) Group(prefix string, m ...echo.MiddlewareFunc) *Group {
+	return &Group{echoGroup: g.echoGroup.Group(prefix, m...), server: g.server}
+}
+
+func (g *Group) routeServer() *Server {
+	return g.server
+}
+
+func New() *Server {
 	return NewWithOptions(Options{})
 }
 
-func NewWithOptions(opts Options) *echo.Echo {
+func NewWithOptions(opts Options) *Server {
 	if opts.LoggerWriter == nil {
 		opts.LoggerWriter = os.Stdout
 	}
@@ -40,103 +207,216 @@ func NewWithOptions(opts Options) *echo.Echo {
 	}
 
 	e := echo.New()
+	s := &Server{Echo: e, services: opts.Services, routeMetas: make(map[*echo.Route]routeMeta)}
 	e.HideBanner = true
 	e.Logger = newGommonLogger(opts.Logger, opts.LoggerWriter)
 	e.Logger.SetLevel(log.INFO)
-	e.HTTPErrorHandler = newErrorHandler(e, opts.Logger, opts.OnHttpError)
-	e.Pre(middleware.RemoveTrailingSlash())
-	e.Use(middleware.RequestID())
-	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			sctx := &Context{Context: c, Validator: opts.Validator, ConfigRaw: opts.Config, ServerLoggerWriter: opts.LoggerWriter, ServerLogger: newContextLogger(c, opts.Logger)}
-			return next(sctx)
-		}
-	})
-	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogMethod:       true,
-		LogURI:          true,
-		LogStatus:       true,
-		LogError:        true,
-		LogLatency:      true,
-		LogResponseSize: true,
-		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			sctx := c.(*Context)
-			evt := sctx.ServerLogger.Info()
-			if v.Error != nil {
-				evt = sctx.ServerLogger.Error()
-			}
+	e.HTTPErrorHandler = newErrorHandler(e, opts)
+	proxies := newProxyResolver(opts.TrustedProxies, opts.ClientIPHeaders)
+	e.IPExtractor = proxies.clientIP
+	configureHttpServers(e, opts)
 
-			evt = evt.Int("status", v.Status).Err(v.Error).Str("latency", v.Latency.String())
-			if v.RequestID != "" {
-				evt = evt.Str("request_id", v.RequestID)
-			}
-			if v.ResponseSize > 0 {
-				evt = evt.Str("size", humanize.Bytes(uint64(v.ResponseSize)))
-			}
+	if mw := newTrailingSlashMiddleware(opts.TrailingSlash, opts.TrailingSlashRedirectCode); mw != nil {
+		e.Pre(mw)
+	}
+	useMiddleware(e, opts.Middleware, MiddlewarePositionPre)
 
-			evt.Msg("request")
-			return nil
-		},
-	}))
-	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) (returnErr error) {
-			defer func() {
-				if r := recover(); r != nil {
-					if r == http.ErrAbortHandler {
-						panic(r)
-					}
-
-					err, ok := r.(error)
-					if !ok {
-						err = fmt.Errorf("%v", r)
-					}
-
-					opts.Logger.Error().Err(err).Msg("recovery handler")
-					returnErr = err
-				}
-			}()
-			return next(c)
-		}
-	})
-	e.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{
-		Skipper: middleware.DefaultSkipper,
-		Timeout: 30 * time.Second,
-	}))
+	if !opts.DisableRequestID {
+		e.Use(defaultMiddleware(opts.RequestID, middleware.RequestID))
+	}
+	e.Use(newTracingMiddleware(opts.Tracer))
+	if opts.Metrics != nil {
+		e.Use(newMetricsMiddleware(opts.Metrics))
+	}
+	useMiddleware(e, opts.Middleware, MiddlewarePositionBeforeContext)
 
-	return e
+	e.Use(newContextMiddleware(s, opts, proxies))
+	if opts.SecurityHeaders != nil {
+		e.Use(newSecurityHeadersMiddleware(*opts.SecurityHeaders))
+	}
+	if opts.CORS != nil || len(opts.RouteCORS) > 0 {
+		e.Use(newCORSMiddleware(opts.CORS, opts.RouteCORS))
+	}
+	if opts.Compression != nil {
+		e.Use(newCompressionMiddleware(*opts.Compression))
+	}
+	useMiddleware(e, opts.Middleware, MiddlewarePositionAfterContext)
+
+	if !opts.DisableRequestLogger {
+		e.Use(defaultMiddleware(opts.RequestLogger, newRequestLoggerMiddleware))
+	}
+	if !opts.DisableRecovery {
+		e.Use(defaultMiddleware(opts.Recovery, func() echo.MiddlewareFunc { return newRecoveryMiddleware(opts) }))
+	}
+	useMiddleware(e, opts.Middleware, MiddlewarePositionAfterRecovery)
+
+	if opts.RateLimit != nil {
+		e.Use(RateLimit(*opts.RateLimit))
+	}
+	e.Use(newBodyLimitMiddleware(opts.BodyLimit, opts.RouteBodyLimits))
+	if opts.Sessions != nil {
+		e.Use(newSessionMiddleware(*opts.Sessions))
+	}
+	if len(opts.Authenticators) > 0 {
+		e.Use(newAuthMiddleware(opts.Authenticators))
+	}
+	if opts.CSRF != nil {
+		e.Use(newCSRFMiddleware(*opts.CSRF))
+	}
+	if opts.Idempotency != nil {
+		e.Use(newIdempotencyMiddleware(*opts.Idempotency))
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultTimeout
+	}
+	e.Use(newTimeoutMiddleware(opts.Timeout, opts.RouteTimeouts, opts.TimeoutSkipper))
+	e.Use(newScopedServicesMiddleware())
+	if opts.Idempotency != nil {
+		e.Use(newIdempotencyHandlerMiddleware())
+	}
+	useMiddleware(e, opts.Middleware, MiddlewarePositionAfterTimeout)
+
+	if exposer, ok := opts.Metrics.(metrics.Exposer); ok {
+		if opts.MetricsPath == "" {
+			opts.MetricsPath = defaultMetricsPath
+		}
+		e.GET(opts.MetricsPath, MetricsHandler(exposer))
+	}
+	if opts.Health != nil {
+		e.GET("/healthz", HealthHandler(opts.Health))
+		e.GET("/readyz", ReadinessHandler(opts.Health))
+		e.GET("/livez", LivenessHandler(opts.Health))
+	}
+	if opts.OpenAPI != nil {
+		path := opts.OpenAPI.Path
+		if path == "" {
+			path = defaultOpenAPIPath
+		}
+		e.GET(path, OpenAPIHandler(opts.OpenAPI.Info))
+	}
+	return s
 }
 
 type StartOptions struct {
+	// GracefulShutdownTimeout bounds the time waiting for in-flight requests
+	// once listeners are closed. It defaults to 10s.
 	GracefulShutdownTimeout time.Duration
+	// DrainPeriod is the time between the start of the shutdown and closing
+	// the listeners, during which IsShuttingDown reports true so that load
+	// balancers stop sending traffic.
+	DrainPeriod time.Duration
+	// OnStart hooks are run in order before the server starts listening. The
+	// first failing hook aborts the start.
+	OnStart []Hook
+	// OnShutdown hooks are run in order after the server has shut down and
+	// before the server services are closed. They are not run if the server
+	// fails to start.
+	OnShutdown []Hook
+
+	// Address is the host to listen on with port. It defaults to all
+	// interfaces.
+	Address string
+	// UnixSocket listens on a Unix domain socket at this path instead of a
+	// TCP port.
+	UnixSocket string
+	// Listener serves on a pre-created listener, for example one bound to
+	// port 0 in tests or passed by socket activation. Port, Address and
+	// UnixSocket are ignored.
+	Listener net.Listener
+
+	// TLSCertFile and TLSKeyFile enable TLS with a certificate that is
+	// reloaded when the files change, checked at most every
+	// TLSCertReloadInterval (10s by default, negative disables reloading).
+	// TLSConfig is used as the base configuration, or on its own if no files
+	// are given. HTTP/2 is enabled with TLS unless e.DisableHTTP2 is set.
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSConfig             *tls.Config
+	TLSCertReloadInterval time.Duration
+	// H2C serves HTTP/2 without TLS.
+	H2C bool
 }
 
-func Start(ctx context.Context, e *echo.Echo, port int) error {
-	return StartWithOptions(ctx, e, port, StartOptions{
-		GracefulShutdownTimeout: 10 * time.Second,
+func Start(ctx context.Context, s *Server, port int) error {
+	return StartWithOptions(ctx, s, port, StartOptions{
+		GracefulShutdownTimeout: defaultGracefulShutdownTimeout,
 	})
 }
 
-func StartWithOptions(ctx context.Context, e *echo.Echo, port int, opts StartOptions) error {
-	go func() {
-		<-ctx.Done()
-
-		ctx, cancel := context.WithTimeout(context.Background(), opts.GracefulShutdownTimeout)
-		defer cancel()
+// StartWithOptions starts the server and blocks until ctx is done and the
+// server has shut down, or the server fails. Shutdown errors are returned
+// rather than logged.
+func StartWithOptions(ctx context.Context, s *Server, port int, opts StartOptions) error {
+	if opts.GracefulShutdownTimeout <= 0 {
+		opts.GracefulShutdownTimeout = defaultGracefulShutdownTimeout
+	}
+	s.shuttingDown.Store(false)
 
-		if err := e.Shutdown(ctx); err != nil {
-			e.Logger.Fatal(err)
+	// The shutdown hooks are not run if the server fails to start, only the
+	// services and the listener are closed.
+	if err := runHooks(opts.OnStart, true); err != nil {
+		if opts.Listener != nil {
+			opts.Listener.Close()
 		}
+		return joinErrors([]error{err, closeServices(s, opts)})
+	}
+
+	serve, err := prepareServer(s.Echo, port, opts)
+	if err != nil {
+		return joinErrors([]error{err, closeServices(s, opts)})
+	}
+
+	startErrCh := make(chan error, 1)
+	go func() {
+		startErrCh <- serve()
 	}()
 
-	if err := e.Start(fmt.Sprintf(":%d", port)); err != nil && err != http.ErrServerClosed {
-		return err
+	select {
+	case err := <-startErrCh:
+		// The server failed, or was shut down directly, before ctx was done.
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		return joinErrors([]error{err, shutdown(s, opts)})
+	case <-ctx.Done():
 	}
 
-	return nil
+	s.shuttingDown.Store(true)
+	if opts.DrainPeriod > 0 {
+		time.Sleep(opts.DrainPeriod)
+	}
+
+	var errs []error
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.GracefulShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("shutting down server: %w", err))
+	}
+	if err := <-startErrCh; err != nil && err != http.ErrServerClosed {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, shutdown(s, opts))
+	return joinErrors(errs)
+}
+
+// shutdown runs the shutdown hooks and closes the server services.
+func shutdown(s *Server, opts StartOptions) error {
+	return joinErrors([]error{runHooks(opts.OnShutdown, false), closeServices(s, opts)})
 }
 
+func closeServices(s *Server, opts StartOptions) error {
+	if s.services == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.GracefulShutdownTimeout)
+	defer cancel()
+	return s.services.Close(ctx)
+}
+
+// Router is a Server or one of its groups.
 type Router interface {
-	Group(prefix string, m ...echo.MiddlewareFunc) *echo.Group
+	Group(prefix string, m ...echo.MiddlewareFunc) *Group
 	Use(middleware ...echo.MiddlewareFunc)
 	CONNECT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
@@ -150,6 +430,8 @@ type Router interface {
 	Any(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) []*echo.Route
 	Match(methods []string, path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) []*echo.Route
 	Add(method, path string, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) *echo.Route
+
+	routeServer() *Server
 }
 
 func AddSubRouter(r Router, path string, subRouterFn func(r Router)) {
@@ -157,14 +439,20 @@ func AddSubRouter(r Router, path string, subRouterFn func(r Router)) {
 	subRouterFn(sr)
 }
 
-func newErrorHandler(e *echo.Echo, logger *zerolog.Logger, onHttpError OnHttpErrorHandler) echo.HTTPErrorHandler {
+func newErrorHandler(e *echo.Echo, opts Options) echo.HTTPErrorHandler {
 	return func(err error, c echo.Context) {
 		if c.Response().Committed {
 			return
 		}
 
-		he, ok := err.(*echo.HTTPError)
-		if ok {
+		var he *echo.HTTPError
+		var problem *Problem
+		if isBodyTooLarge(err) {
+			he = echo.ErrStatusRequestEntityTooLarge
+		} else if errors.As(err, &problem) {
+			he = problem.httpError()
+		} else if herr, ok := err.(*echo.HTTPError); ok {
+			he = herr
 			if he.Internal != nil {
 				if herr, ok := he.Internal.(*echo.HTTPError); ok {
 					he = herr
@@ -174,45 +462,94 @@ func newErrorHandler(e *echo.Echo, logger *zerolog.Logger, onHttpError OnHttpErr
 			he = echo.NewHTTPError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		}
 
-		if onHttpError != nil {
-			onHttpError(c, he)
-		}
-
-		code := he.Code
-		message := he.Message
-
-		switch m := message.(type) {
-		case string:
-			if e.Debug {
-				message = echo.Map{"error": m, "description": err.Error()}
-			} else {
-				message = echo.Map{"error": m}
-			}
-		case json.Marshaler:
-			message = echo.Map{"error": m}
-		case error:
-			message = echo.Map{"error": m.Error()}
+		if opts.OnHttpError != nil {
+			opts.OnHttpError(c, he)
 		}
 
 		if c.Request().Method == http.MethodHead {
 			err = c.NoContent(he.Code)
+		} else if opts.ProblemDetails && acceptsProblemJSON(c.Request().Header.Get(echo.HeaderAccept)) {
+			err = writeProblem(e, c, he, err)
 		} else {
-			err = c.JSON(code, message)
+			err = writeLegacyError(e, c, he, err)
 		}
 
-		logger = newContextLogger(c, logger)
 		if err != nil {
-			logger.Error().Err(err).Msg("error handler")
+			newContextLogger(c, opts.Logger).Error().Err(err).Msg("error handler")
 		}
 	}
 }
 
-func newContextLogger(c echo.Context, logger *zerolog.Logger) *zerolog.Logger {
+func writeLegacyError(e *echo.Echo, c echo.Context, he *echo.HTTPError, err error) error {
+	code := he.Code
+	message := he.Message
+
+	switch m := message.(type) {
+	case string:
+		if e.Debug {
+			message = echo.Map{"error": m, "description": err.Error()}
+		} else {
+			message = echo.Map{"error": m}
+		}
+	case json.Marshaler:
+		message = echo.Map{"error": m}
+	case error:
+		message = echo.Map{"error": m.Error()}
+	}
+
+	// Extension members of problems, like validation errors, are kept in
+	// the legacy shape too.
+	if problem, ok := he.Internal.(*Problem); ok {
+		if m, ok := message.(echo.Map); ok {
+			for k, v := range problem.Extensions {
+				if _, exists := m[k]; !exists {
+					m[k] = v
+				}
+			}
+		}
+	}
+
+	return c.JSON(code, message)
+}
+
+func writeProblem(e *echo.Echo, c echo.Context, he *echo.HTTPError, err error) error {
+	problem := *problemFromHttpError(he)
+	if problem.Instance == "" {
+		problem.Instance = getRequestId(c)
+	}
+	if e.Debug {
+		extensions := make(map[string]any, len(problem.Extensions)+1)
+		for k, v := range problem.Extensions {
+			extensions[k] = v
+		}
+		extensions["description"] = err.Error()
+		problem.Extensions = extensions
+	}
+
+	bs, err := json.Marshal(&problem)
+	if err != nil {
+		return err
+	}
+	return c.Blob(problem.Status, MIMEApplicationProblemJSON, bs)
+}
+
+func getRequestId(c echo.Context) string {
 	requestId := c.Request().Header.Get(echo.HeaderXRequestID)
+	if requestId == "" {
+		requestId = c.Response().Header().Get(echo.HeaderXRequestID)
+	}
+	return requestId
+}
+
+func newContextLogger(c echo.Context, logger *zerolog.Logger) *zerolog.Logger {
+	requestId := getRequestId(c)
 	loggerBuilder := logger.With().Str("method", c.Request().Method).Str("uri", c.Request().RequestURI)
 	if requestId != "" {
 		loggerBuilder = loggerBuilder.Str("request_id", requestId)
 	}
+	if sc := tracing.SpanContextFromContext(c.Request().Context()); sc.IsValid() {
+		loggerBuilder = loggerBuilder.Str("trace_id", sc.TraceID.String()).Str("span_id", sc.SpanID.String())
+	}
 	loggerStruct := loggerBuilder.Logger()
 	return &loggerStruct
 }
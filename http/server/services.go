@@ -0,0 +1,460 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Services is a registry of typed services, like database pools and clients,
+// made available to handlers through the server Context. Services are keyed
+// by their type and an optional name.
+//
+// Singletons are constructed lazily on first use, at most once at a time, and
+// closed in reverse construction order by Close, which StartWithOptions
+// calls once the server has shut down. Resolving a singleton that depends on
+// itself fails instead of deadlocking. Request scoped services are constructed at most once per
+// request and closed when the request ends, or when its handler returns if
+// it outlives a request that timed out.
+type Services struct {
+	*serviceRegistry
+}
+
+type serviceRegistry struct {
+	mu      sync.Mutex
+	entries map[serviceKey]*serviceEntry
+	// constructed holds the singletons in construction order.
+	constructed []*serviceEntry
+	closed      bool
+	// waiting maps goroutines waiting for the construction of a singleton
+	// to it, used to detect dependency cycles.
+	waiting map[uint64]*singletonCall
+}
+
+type serviceKey struct {
+	typ  reflect.Type
+	name string
+}
+
+func (k serviceKey) String() string {
+	if k.name == "" {
+		return k.typ.String()
+	}
+	return fmt.Sprintf("%s (%s)", k.typ, k.name)
+}
+
+type serviceEntry struct {
+	key    serviceKey
+	scoped bool
+
+	construct       func(s *Services) (any, error)
+	constructScoped func(c *Context) (any, error)
+	close           func(ctx context.Context, v any) error
+
+	// done, value and call are the state of singletons, guarded by the
+	// registry mutex.
+	done  bool
+	value any
+	call  *singletonCall
+}
+
+// singletonCall is the construction of a singleton by a goroutine, waited for
+// by the other goroutines resolving it.
+type singletonCall struct {
+	goroutine uint64
+	done      chan struct{}
+	err       error
+}
+
+type ProvideOptions[T any] struct {
+	// Name distinguishes multiple services of the same type.
+	Name string
+	// Close closes the service. If nil, services implementing io.Closer,
+	// interface{ Close() } or interface{ Close(context.Context) error } are
+	// closed with their Close method.
+	Close func(ctx context.Context, v T) error
+}
+
+func NewServices() *Services {
+	return &Services{serviceRegistry: &serviceRegistry{
+		entries: make(map[serviceKey]*serviceEntry),
+		waiting: make(map[uint64]*singletonCall),
+	}}
+}
+
+// Provide registers a lazily constructed singleton of type T. The constructor
+// can resolve the services it depends on with Resolve.
+func Provide[T any](s *Services, constructor func(s *Services) (T, error)) {
+	ProvideWithOptions(s, constructor, ProvideOptions[T]{})
+}
+
+func ProvideWithOptions[T any](s *Services, constructor func(s *Services) (T, error), opts ProvideOptions[T]) {
+	s.register(&serviceEntry{
+		key: serviceKey{typ: reflect.TypeFor[T](), name: opts.Name},
+		construct: func(s *Services) (any, error) {
+			return constructor(s)
+		},
+		close: typedClose(opts.Close),
+	})
+}
+
+// ProvideValue registers an already constructed singleton of type T.
+func ProvideValue[T any](s *Services, v T) {
+	Provide(s, func(*Services) (T, error) { return v, nil })
+}
+
+// ProvideScoped registers a request scoped service of type T.
+func ProvideScoped[T any](s *Services, constructor func(c *Context) (T, error)) {
+	ProvideScopedWithOptions(s, constructor, ProvideOptions[T]{})
+}
+
+func ProvideScopedWithOptions[T any](s *Services, constructor func(c *Context) (T, error), opts ProvideOptions[T]) {
+	s.register(&serviceEntry{
+		key:    serviceKey{typ: reflect.TypeFor[T](), name: opts.Name},
+		scoped: true,
+		constructScoped: func(c *Context) (any, error) {
+			return constructor(c)
+		},
+		close: typedClose(opts.Close),
+	})
+}
+
+func typedClose[T any](closeFn func(ctx context.Context, v T) error) func(ctx context.Context, v any) error {
+	if closeFn == nil {
+		return closeService
+	}
+	return func(ctx context.Context, v any) error {
+		return closeFn(ctx, v.(T))
+	}
+}
+
+func (s *Services) register(entry *serviceEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[entry.key]; ok {
+		panic(fmt.Sprintf("service %s already registered", entry.key))
+	}
+	s.entries[entry.key] = entry
+}
+
+func (s *Services) entry(key serviceKey) (*serviceEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("services are closed")
+	}
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, fmt.Errorf("service %s is not registered", key)
+	}
+	return entry, nil
+}
+
+// Resolve returns the singleton of type T, constructing it if needed.
+func Resolve[T any](s *Services) (T, error) {
+	return ResolveNamed[T](s, "")
+}
+
+func ResolveNamed[T any](s *Services, name string) (T, error) {
+	var zero T
+	key := serviceKey{typ: reflect.TypeFor[T](), name: name}
+	entry, err := s.entry(key)
+	if err != nil {
+		return zero, err
+	}
+	if entry.scoped {
+		return zero, fmt.Errorf("service %s is request scoped", key)
+	}
+
+	v, err := s.singleton(entry)
+	if err != nil {
+		return zero, err
+	}
+	return v.(T), nil
+}
+
+// singleton returns the singleton of entry, constructing it if needed. The
+// constructor runs without locks, and goroutines resolving the singleton
+// meanwhile wait for it, unless waiting would deadlock because the
+// construction depends on the singleton.
+func (s *Services) singleton(entry *serviceEntry) (v any, err error) {
+	s.mu.Lock()
+	if entry.done {
+		defer s.mu.Unlock()
+		return entry.value, nil
+	}
+
+	g := goroutineID()
+	for entry.call != nil {
+		call := entry.call
+		if s.waitsFor(call, g) {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("service %s depends on itself", entry.key)
+		}
+		s.waiting[g] = call
+		s.mu.Unlock()
+		<-call.done
+		s.mu.Lock()
+		delete(s.waiting, g)
+		if entry.done {
+			defer s.mu.Unlock()
+			return entry.value, nil
+		}
+		if call.err != nil {
+			s.mu.Unlock()
+			return nil, call.err
+		}
+	}
+
+	call := &singletonCall{goroutine: g, done: make(chan struct{})}
+	entry.call = call
+	s.mu.Unlock()
+
+	// The construction is finished even if the constructor panics, so that
+	// the waiting goroutines fail instead of blocking forever.
+	call.err = fmt.Errorf("constructing service %s: constructor panicked", entry.key)
+	defer func() {
+		s.mu.Lock()
+		entry.call = nil
+		closed := call.err == nil && s.closed
+		if call.err == nil && !closed {
+			entry.done = true
+			entry.value = v
+			s.constructed = append(s.constructed, entry)
+		}
+		s.mu.Unlock()
+
+		// Singletons constructed while the services were being closed are
+		// closed right away.
+		if closed {
+			call.err = fmt.Errorf("service %s: services are closed", entry.key)
+			if closeErr := entry.close(context.Background(), v); closeErr != nil {
+				call.err = errors.Join(call.err, fmt.Errorf("closing service %s: %w", entry.key, closeErr))
+			}
+			v, err = nil, call.err
+		}
+		close(call.done)
+	}()
+
+	v, err = entry.construct(s)
+	if err != nil {
+		// Failed constructions are retried on next use.
+		call.err = fmt.Errorf("constructing service %s: %w", entry.key, err)
+		return nil, call.err
+	}
+	call.err = nil
+	return v, nil
+}
+
+// waitsFor reports whether the construction of call is run by goroutine g, or
+// waits for another construction run by g.
+func (s *serviceRegistry) waitsFor(call *singletonCall, g uint64) bool {
+	for call != nil {
+		if call.goroutine == g {
+			return true
+		}
+		call = s.waiting[call.goroutine]
+	}
+	return false
+}
+
+// Close closes the constructed singletons in reverse construction order and
+// returns the joined errors. Services cannot be resolved afterwards.
+func (s *Services) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	constructed := s.constructed
+	s.constructed = nil
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(constructed) - 1; i >= 0; i-- {
+		entry := constructed[i]
+		if err := entry.close(ctx, entry.value); err != nil {
+			errs = append(errs, fmt.Errorf("closing service %s: %w", entry.key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func closeService(ctx context.Context, v any) error {
+	switch c := v.(type) {
+	case interface{ Close(context.Context) error }:
+		return c.Close(ctx)
+	case io.Closer:
+		return c.Close()
+	case interface{ Close() }:
+		c.Close()
+	}
+	return nil
+}
+
+// goroutineID returns the id of the current goroutine, from the header of its
+// stack trace, e.g. "goroutine 1 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+type scopedService struct {
+	entry *serviceEntry
+	value any
+}
+
+// scopedServices are the request scoped services of a request. The timeout
+// middleware runs handlers in their own goroutine, which outlives the request
+// if it times out, so the services are reference counted and closed once
+// both the request and its handler are done.
+type scopedServices struct {
+	// ctx is the context services are closed with. It is not canceled with
+	// the request.
+	ctx context.Context
+
+	mu       sync.Mutex
+	services []scopedService
+	refs     int
+}
+
+func newScopedServices(ctx context.Context) *scopedServices {
+	return &scopedServices{ctx: context.WithoutCancel(ctx), refs: 1}
+}
+
+func (s *scopedServices) get(entry *serviceEntry) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, scoped := range s.services {
+		if scoped.entry == entry {
+			return scoped.value, true
+		}
+	}
+	return nil, false
+}
+
+// add stores the service constructed for entry and returns it, or the
+// service constructed concurrently for entry if there is one.
+func (s *scopedServices) add(entry *serviceEntry, v any) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, scoped := range s.services {
+		if scoped.entry == entry {
+			return scoped.value, false
+		}
+	}
+	s.services = append(s.services, scopedService{entry: entry, value: v})
+	return v, true
+}
+
+func (s *scopedServices) acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs++
+}
+
+// release returns the services to close once the last reference is released,
+// in construction order.
+func (s *scopedServices) release() []scopedService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	services := s.services
+	s.services = nil
+	return services
+}
+
+// Service returns the service of type T for the request, which is either a
+// request scoped service or a singleton.
+func Service[T any](c echo.Context) (T, error) {
+	return NamedService[T](c, "")
+}
+
+func NamedService[T any](c echo.Context, name string) (T, error) {
+	var zero T
+	sctx := GetContext(c)
+	if sctx.Services == nil {
+		return zero, errors.New("server has no services")
+	}
+
+	key := serviceKey{typ: reflect.TypeFor[T](), name: name}
+	entry, err := sctx.Services.entry(key)
+	if err != nil {
+		return zero, err
+	}
+	if !entry.scoped {
+		v, err := sctx.Services.singleton(entry)
+		if err != nil {
+			return zero, err
+		}
+		return v.(T), nil
+	}
+
+	if v, ok := sctx.scopedServices.get(entry); ok {
+		return v.(T), nil
+	}
+	v, err := entry.constructScoped(sctx)
+	if err != nil {
+		return zero, fmt.Errorf("constructing service %s: %w", key, err)
+	}
+	if existing, added := sctx.scopedServices.add(entry, v); !added {
+		sctx.closeScopedService(scopedService{entry: entry, value: v})
+		return existing.(T), nil
+	}
+	return v.(T), nil
+}
+
+// MustService is like Service but panics if the service cannot be returned.
+func MustService[T any](c echo.Context) T {
+	v, err := Service[T](c)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// newScopedServicesMiddleware returns a middleware holding a reference to the
+// request scoped services while the handler runs, within the timeout.
+func newScopedServicesMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			sctx := GetContext(c)
+			sctx.scopedServices.acquire()
+			defer sctx.releaseScopedServices()
+			return next(c)
+		}
+	}
+}
+
+// releaseScopedServices releases a reference to the request scoped services
+// of the request, closing them in reverse construction order if it is the
+// last one.
+func (c *Context) releaseScopedServices() {
+	services := c.scopedServices.release()
+	for i := len(services) - 1; i >= 0; i-- {
+		c.closeScopedService(services[i])
+	}
+}
+
+func (c *Context) closeScopedService(scoped scopedService) {
+	if err := scoped.entry.close(c.scopedServices.ctx, scoped.value); err != nil {
+		c.ServerLogger.Error().Err(err).Str("service", scoped.entry.key.String()).Msg("closing request scoped service")
+	}
+}
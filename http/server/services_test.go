@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type scopedTestService struct {
+	closed atomic.Bool
+}
+
+func (s *scopedTestService) Close() {
+	s.closed.Store(true)
+}
+
+func TestScopedServicesClosedAfterRequest(t *testing.T) {
+	services := NewServices()
+	var constructed []*scopedTestService
+	ProvideScoped(services, func(*Context) (*scopedTestService, error) {
+		s := &scopedTestService{}
+		constructed = append(constructed, s)
+		return s, nil
+	})
+
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, Services: services})
+	e.GET("/", func(c echo.Context) error {
+		first := MustService[*scopedTestService](c)
+		if second := MustService[*scopedTestService](c); first != second {
+			t.Error("scoped service constructed twice in a request")
+		}
+		if first.closed.Load() {
+			t.Error("scoped service closed while in use")
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for i := 0; i < 2; i++ {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if len(constructed) != 2 {
+		t.Fatalf("constructed %d scoped services, want 2", len(constructed))
+	}
+	for i, s := range constructed {
+		if !s.closed.Load() {
+			t.Errorf("scoped service %d not closed after its request", i)
+		}
+	}
+}
+
+func TestScopedServicesClosedAfterTimedOutHandler(t *testing.T) {
+	services := NewServices()
+	closedCh := make(chan *scopedTestService, 1)
+	ProvideScopedWithOptions(services, func(*Context) (*scopedTestService, error) {
+		return &scopedTestService{}, nil
+	}, ProvideOptions[*scopedTestService]{Close: func(_ context.Context, s *scopedTestService) error {
+		s.Close()
+		closedCh <- s
+		return nil
+	}})
+
+	handlerDone := make(chan bool, 1)
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, Services: services, Timeout: 20 * time.Millisecond})
+	e.GET("/", func(c echo.Context) error {
+		s := MustService[*scopedTestService](c)
+		time.Sleep(100 * time.Millisecond)
+		handlerDone <- s.closed.Load()
+		// The response is not written as it was already sent by the timeout
+		// middleware.
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	select {
+	case closedInUse := <-handlerDone:
+		if closedInUse {
+			t.Fatal("scoped service closed while the timed out handler was using it")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler did not finish")
+	}
+	select {
+	case <-closedCh:
+	case <-time.After(time.Second):
+		t.Fatal("scoped service not closed after the timed out handler finished")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	services := NewServices()
+	ProvideWithOptions(services, func(*Services) (*scopedTestService, error) {
+		return &scopedTestService{}, nil
+	}, ProvideOptions[*scopedTestService]{})
+	service, err := Resolve[*scopedTestService](services)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewWithOptions(Options{LoggerWriter: io.Discard, Services: services})
+	// The server state does not depend on the configuration of the http
+	// server.
+	s.Server.BaseContext = func(net.Listener) context.Context {
+		return context.Background()
+	}
+	if IsShuttingDown(s) {
+		t.Error("new server is shutting down")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var shuttingDown bool
+	err = StartWithOptions(ctx, s, 0, StartOptions{
+		Listener: l,
+		OnShutdown: []Hook{{Fn: func(context.Context) error {
+			shuttingDown = IsShuttingDown(s)
+			return nil
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !shuttingDown {
+		t.Error("server not shutting down in shutdown hooks")
+	}
+	if !service.closed.Load() {
+		t.Error("server services not closed after shutdown")
+	}
+}
+
+func TestServerStartFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		opts StartOptions
+	}{
+		{
+			name: "start hook",
+			opts: StartOptions{OnStart: []Hook{{Fn: func(context.Context) error {
+				return errors.New("start failed")
+			}}}},
+		},
+		{
+			name: "tls",
+			opts: StartOptions{TLSCertFile: "missing.pem", TLSKeyFile: "missing.pem"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := NewServices()
+			ProvideWithOptions(services, func(*Services) (*scopedTestService, error) {
+				return &scopedTestService{}, nil
+			}, ProvideOptions[*scopedTestService]{})
+			service, err := Resolve[*scopedTestService](services)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := NewWithOptions(Options{LoggerWriter: io.Discard, Services: services})
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var shutdownHookRun bool
+			tt.opts.Listener = l
+			tt.opts.OnShutdown = []Hook{{Fn: func(context.Context) error {
+				shutdownHookRun = true
+				return nil
+			}}}
+			if err := StartWithOptions(context.Background(), s, 0, tt.opts); err == nil {
+				t.Fatal("StartWithOptions succeeded")
+			}
+
+			if shutdownHookRun {
+				t.Error("shutdown hook run for a server that never started")
+			}
+			if !service.closed.Load() {
+				t.Error("server services not closed")
+			}
+			if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("listener Accept error = %v, want %v", err, net.ErrClosed)
+			}
+		})
+	}
+}
+
+type cycleTestA struct{}
+
+type cycleTestB struct{}
+
+func TestServicesDependencyCycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		resolve func(captured, resolver *Services) *Services
+	}{
+		{name: "resolver", resolve: func(_, resolver *Services) *Services { return resolver }},
+		{name: "captured services", resolve: func(captured, _ *Services) *Services { return captured }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := NewServices()
+			Provide(services, func(s *Services) (*cycleTestA, error) {
+				_, err := Resolve[*cycleTestB](tt.resolve(services, s))
+				return &cycleTestA{}, err
+			})
+			Provide(services, func(s *Services) (*cycleTestB, error) {
+				_, err := Resolve[*cycleTestA](tt.resolve(services, s))
+				return &cycleTestB{}, err
+			})
+
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := Resolve[*cycleTestA](services)
+				errCh <- err
+			}()
+			select {
+			case err := <-errCh:
+				if err == nil || !strings.Contains(err.Error(), "depends on itself") {
+					t.Errorf("err = %v, want a dependency cycle error", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("resolving a dependency cycle deadlocked")
+			}
+		})
+	}
+}
+
+func TestServicesConcurrentResolve(t *testing.T) {
+	services := NewServices()
+	var constructions atomic.Int32
+	Provide(services, func(*Services) (*scopedTestService, error) {
+		constructions.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return &scopedTestService{}, nil
+	})
+
+	var wg sync.WaitGroup
+	results := make([]*scopedTestService, 8)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = Resolve[*scopedTestService](services)
+		}()
+	}
+	wg.Wait()
+
+	if n := constructions.Load(); n != 1 {
+		t.Errorf("constructions = %d, want 1", n)
+	}
+	for i, v := range results {
+		if v == nil || v != results[0] {
+			t.Errorf("result %d = %p, want %p", i, v, results[0])
+		}
+	}
+}
+
+func TestServicesConstructionFailure(t *testing.T) {
+	services := NewServices()
+	var constructions atomic.Int32
+	Provide(services, func(*Services) (*scopedTestService, error) {
+		if constructions.Add(1) == 1 {
+			return nil, errors.New("unavailable")
+		}
+		return &scopedTestService{}, nil
+	})
+
+	if _, err := Resolve[*scopedTestService](services); err == nil {
+		t.Fatal("first Resolve succeeded")
+	}
+	if _, err := Resolve[*scopedTestService](services); err != nil {
+		t.Fatalf("Resolve after a failed construction: %v", err)
+	}
+}
+
+func TestServicesClosedDuringConstruction(t *testing.T) {
+	services := NewServices()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var constructed *scopedTestService
+	Provide(services, func(*Services) (*scopedTestService, error) {
+		close(started)
+		<-release
+		constructed = &scopedTestService{}
+		return constructed, nil
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := Resolve[*scopedTestService](services)
+		errCh <- err
+	}()
+	<-started
+	if err := services.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	if err := <-errCh; err == nil {
+		t.Error("Resolve succeeded after Close")
+	}
+	if !constructed.closed.Load() {
+		t.Error("service constructed during Close not closed")
+	}
+}
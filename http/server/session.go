@@ -0,0 +1,340 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSessionCookieName      = "session"
+	defaultSessionIdleTimeout     = 24 * time.Hour
+	defaultSessionAbsoluteTimeout = 7 * 24 * time.Hour
+	// sessionTouchInterval bounds how often unmodified sessions are saved to
+	// extend their idle timeout.
+	sessionTouchInterval = time.Minute
+	sessionIDLength      = 32
+	sessionCSRFTokenKey  = "_csrf"
+)
+
+var (
+	ErrNoSession = errors.New("sessions are not enabled")
+)
+
+// SessionOptions configures the sessions of the server.
+type SessionOptions struct {
+	// Store is required, see NewCookieSessionStore and
+	// NewMemorySessionStore.
+	Store SessionStore
+
+	// Cookie attributes. The cookie is named session, is HTTP only, and is
+	// secure and SameSite=Lax by default.
+	CookieName     string
+	CookiePath     string
+	CookieDomain   string
+	CookieInsecure bool
+	CookieSameSite http.SameSite
+
+	// IdleTimeout expires sessions unused for that long, defaulting to 24h.
+	// AbsoluteTimeout expires sessions that long after their creation,
+	// defaulting to 7 days. A negative timeout disables it.
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+// Session is the session of a request. It is saved before the response is
+// written if it was modified. New sessions are only saved once modified, so
+// that anonymous clients get no cookie.
+type Session struct {
+	data  SessionData
+	token string
+
+	isNew       bool
+	modified    bool
+	regenerated bool
+	destroyed   bool
+	clearCookie bool
+}
+
+func newSession(now time.Time) (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{data: SessionData{ID: id, CreatedAt: now, LastSeenAt: now}, isNew: true}, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, sessionIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating session id")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *Session) ID() string {
+	return s.data.ID
+}
+
+// IsNew reports whether the session was created by the request.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) CreatedAt() time.Time {
+	return s.data.CreatedAt
+}
+
+// Get decodes the value of key into v and reports whether it exists.
+func (s *Session) Get(key string, v any) (bool, error) {
+	raw, ok := s.data.Values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Set sets the value of key. The value must be JSON serializable.
+func (s *Session) Set(key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.data.Values == nil {
+		s.data.Values = make(map[string]json.RawMessage)
+	}
+	s.data.Values[key] = raw
+	s.modified = true
+	return nil
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.modified = true
+	}
+}
+
+// AddFlash adds a message to be read by a later request with Flashes.
+func (s *Session) AddFlash(message string) {
+	s.data.Flashes = append(s.data.Flashes, message)
+	s.modified = true
+}
+
+// Flashes returns and removes the flash messages of the session.
+func (s *Session) Flashes() []string {
+	flashes := s.data.Flashes
+	if len(flashes) > 0 {
+		s.data.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// Regenerate gives the session a new id, keeping its values, and restarts
+// its absolute timeout. It must be called when the privileges of the client
+// change, e.g. on sign in, to prevent session fixation. The old session is
+// deleted from the store, which does not revoke cookie sessions, see
+// NewCookieSessionStore.
+func (s *Session) Regenerate() error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s.data.ID = id
+	s.data.CreatedAt = now
+	s.data.LastSeenAt = now
+	s.regenerated = true
+	s.destroyed = false
+	return nil
+}
+
+// Destroy deletes the session and its cookie, e.g. on sign out. Copies of
+// the cookie of a cookie session stay valid, see NewCookieSessionStore.
+func (s *Session) Destroy() {
+	s.data.Values = nil
+	s.data.Flashes = nil
+	s.destroyed = true
+}
+
+type sessionManager struct {
+	opts SessionOptions
+}
+
+func newSessionMiddleware(opts SessionOptions) echo.MiddlewareFunc {
+	if opts.Store == nil {
+		panic("server: sessions require a store")
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = defaultSessionCookieName
+	}
+	if opts.CookiePath == "" {
+		opts.CookiePath = "/"
+	}
+	if opts.CookieSameSite == 0 {
+		opts.CookieSameSite = http.SameSiteLaxMode
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = defaultSessionIdleTimeout
+	}
+	if opts.AbsoluteTimeout == 0 {
+		opts.AbsoluteTimeout = defaultSessionAbsoluteTimeout
+	}
+	m := &sessionManager{opts: opts}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			session, err := m.load(c, time.Now())
+			if err != nil {
+				return err
+			}
+
+			sctx := GetContext(c)
+			sctx.Session = session
+			c.Response().Before(func() {
+				if err := m.save(c, session, time.Now()); err != nil {
+					sctx.ServerLogger.Error().Err(err).Msg("saving session")
+				}
+			})
+			return next(c)
+		}
+	}
+}
+
+func (m *sessionManager) load(c echo.Context, now time.Time) (*Session, error) {
+	cookie, err := c.Cookie(m.opts.CookieName)
+	if err != nil || cookie.Value == "" {
+		return newSession(now)
+	}
+
+	ctx := c.Request().Context()
+	data, err := m.opts.Store.Load(ctx, cookie.Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading session")
+	}
+	if data == nil || m.isExpired(data, now) {
+		if data != nil {
+			if err := m.opts.Store.Delete(ctx, cookie.Value); err != nil {
+				return nil, errors.Wrap(err, "deleting session")
+			}
+		}
+		session, err := newSession(now)
+		if err != nil {
+			return nil, err
+		}
+		session.clearCookie = true
+		return session, nil
+	}
+	return &Session{data: *data, token: cookie.Value}, nil
+}
+
+func (m *sessionManager) isExpired(data *SessionData, now time.Time) bool {
+	if m.opts.IdleTimeout > 0 && now.Sub(data.LastSeenAt) >= m.opts.IdleTimeout {
+		return true
+	}
+	return m.opts.AbsoluteTimeout > 0 && now.Sub(data.CreatedAt) >= m.opts.AbsoluteTimeout
+}
+
+func (m *sessionManager) save(c echo.Context, s *Session, now time.Time) error {
+	ctx := c.Request().Context()
+	if s.destroyed {
+		if s.token != "" {
+			if err := m.opts.Store.Delete(ctx, s.token); err != nil {
+				return err
+			}
+		}
+		if s.token != "" || s.clearCookie {
+			m.setCookie(c, "", -1)
+		}
+		return nil
+	}
+
+	touch := !s.isNew && now.Sub(s.data.LastSeenAt) >= sessionTouchInterval
+	if !s.modified && !s.regenerated && !touch {
+		if s.clearCookie {
+			m.setCookie(c, "", -1)
+		}
+		return nil
+	}
+
+	if s.regenerated && s.token != "" {
+		if err := m.opts.Store.Delete(ctx, s.token); err != nil {
+			return err
+		}
+	}
+
+	s.data.LastSeenAt = now
+	ttl := m.opts.IdleTimeout
+	maxAge := time.Duration(0)
+	if m.opts.AbsoluteTimeout > 0 {
+		maxAge = m.opts.AbsoluteTimeout - now.Sub(s.data.CreatedAt)
+		if ttl <= 0 || maxAge < ttl {
+			ttl = maxAge
+		}
+	}
+	if ttl <= 0 {
+		ttl = defaultSessionAbsoluteTimeout
+	}
+
+	token, err := m.opts.Store.Save(ctx, &s.data, ttl)
+	if err != nil {
+		return err
+	}
+	s.token = token
+	m.setCookie(c, token, maxAge)
+	return nil
+}
+
+// setCookie sets the session cookie. A zero maxAge makes it a browser session
+// cookie and a negative one deletes it.
+func (m *sessionManager) setCookie(c echo.Context, value string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.CookiePath,
+		Domain:   m.opts.CookieDomain,
+		Secure:   !m.opts.CookieInsecure,
+		HttpOnly: true,
+		SameSite: m.opts.CookieSameSite,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(0, 0)
+	} else if maxAge > 0 {
+		cookie.MaxAge = int(maxAge / time.Second)
+		cookie.Expires = time.Now().Add(maxAge)
+	}
+	c.SetCookie(cookie)
+}
+
+type sessionCSRFTokenStore struct{}
+
+// SessionCSRFTokenStore returns a CSRFTokenStore keeping synchronizer tokens
+// in the session of the request.
+func SessionCSRFTokenStore() CSRFTokenStore {
+	return sessionCSRFTokenStore{}
+}
+
+func (sessionCSRFTokenStore) GetToken(c echo.Context) (string, error) {
+	session := GetContext(c).Session
+	if session == nil {
+		return "", ErrNoSession
+	}
+	var token string
+	_, err := session.Get(sessionCSRFTokenKey, &token)
+	return token, err
+}
+
+func (sessionCSRFTokenStore) SetToken(c echo.Context, token string) error {
+	session := GetContext(c).Session
+	if session == nil {
+		return ErrNoSession
+	}
+	return session.Set(sessionCSRFTokenKey, token)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cookieSessionVersion = 1
+	maxCookieSessionSize = 4000
+	memorySessionSweep   = time.Minute
+)
+
+var (
+	ErrSessionTooLarge = errors.New("session too large for a cookie")
+)
+
+// SessionData is the stored state of a session. Values are JSON encoded.
+type SessionData struct {
+	ID         string                     `json:"id"`
+	Values     map[string]json.RawMessage `json:"values,omitempty"`
+	Flashes    []string                   `json:"flashes,omitempty"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	LastSeenAt time.Time                  `json:"last_seen_at"`
+}
+
+// SessionStore stores sessions. The token of a session is the value of its
+// cookie.
+type SessionStore interface {
+	// Load returns the session of token, or nil if there is no such session.
+	Load(ctx context.Context, token string) (*SessionData, error)
+	// Save saves the session for at least ttl and returns its token.
+	Save(ctx context.Context, data *SessionData, ttl time.Duration) (string, error)
+	// Delete deletes the session of token.
+	Delete(ctx context.Context, token string) error
+}
+
+type cookieSessionStore struct {
+	aeads []cipher.AEAD
+}
+
+// NewCookieSessionStore returns a store keeping sessions in their cookie,
+// encrypted and authenticated with AES-GCM. Keys must be 16, 24 or 32 bytes
+// long. The first key encrypts sessions and all keys decrypt them, so keys
+// can be rotated by prepending a new key and removing the old one once its
+// sessions have expired.
+//
+// Cookie sessions cannot be revoked: Delete is a no-op, so the cookie of a
+// session destroyed or regenerated by Session.Destroy or Session.Regenerate
+// stays valid until its idle or absolute timeout if a client kept a copy of
+// it. Use a server side store, e.g. MemorySessionStore, if sessions must be
+// revoked on sign out, or keep the timeouts of cookie sessions short.
+func NewCookieSessionStore(keys ...[]byte) (SessionStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("cookie session store requires a key")
+	}
+
+	s := &cookieSessionStore{}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "session key %d", i)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "session key %d", i)
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s, nil
+}
+
+func (s *cookieSessionStore) Load(_ context.Context, token string) (*SessionData, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 1 || b[0] != cookieSessionVersion {
+		return nil, nil
+	}
+	b = b[1:]
+
+	for _, aead := range s.aeads {
+		if len(b) < aead.NonceSize() {
+			continue
+		}
+		plaintext, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte{cookieSessionVersion})
+		if err != nil {
+			continue
+		}
+
+		var data SessionData
+		if err := json.Unmarshal(plaintext, &data); err != nil {
+			return nil, nil
+		}
+		return &data, nil
+	}
+	// Sessions sealed with unknown keys, e.g. removed ones, are treated as
+	// missing.
+	return nil, nil
+}
+
+func (s *cookieSessionStore) Save(_ context.Context, data *SessionData, _ time.Duration) (string, error) {
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	aead := s.aeads[0]
+	b := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	b[0] = cookieSessionVersion
+	if _, err := rand.Read(b[1:]); err != nil {
+		return "", err
+	}
+	b = aead.Seal(b, b[1:], plaintext, []byte{cookieSessionVersion})
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if len(token) > maxCookieSessionSize {
+		return "", ErrSessionTooLarge
+	}
+	return token, nil
+}
+
+// Delete does nothing as the session lives in its cookie, see
+// NewCookieSessionStore.
+func (s *cookieSessionStore) Delete(context.Context, string) error {
+	return nil
+}
+
+// MemorySessionStore is an in-memory SessionStore, keyed by session id.
+// Sessions are lost on restart and not shared between processes.
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+}
+
+type memorySession struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+func (s *MemorySessionStore) Load(_ context.Context, token string) (*SessionData, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[token]
+	s.mu.Unlock()
+	if !ok || !time.Now().Before(session.expiresAt) {
+		return nil, nil
+	}
+
+	var data SessionData
+	if err := json.Unmarshal(session.data, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *MemorySessionStore) Save(_ context.Context, data *SessionData, ttl time.Duration) (string, error) {
+	// Sessions are stored encoded so that they are not shared with the
+	// handlers of concurrent requests.
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= memorySessionSweep {
+		for id, session := range s.sessions {
+			if !now.Before(session.expiresAt) {
+				delete(s.sessions, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.sessions[data.ID] = memorySession{data: b, expiresAt: now.Add(ttl)}
+	return data.ID, nil
+}
+
+func (s *MemorySessionStore) Delete(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+	return nil
+}
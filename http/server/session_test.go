@@ -0,0 +1,236 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newSessionTestServer(t *testing.T, opts SessionOptions) *Server {
+	t.Helper()
+	opts.CookieInsecure = true
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, Sessions: &opts})
+	e.POST("/set", func(c echo.Context) error {
+		if err := GetContext(c).Session.Set("v", c.QueryParam("v")); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/get", func(c echo.Context) error {
+		var v string
+		if _, err := GetContext(c).Session.Get("v", &v); err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, v)
+	})
+	e.POST("/regenerate", func(c echo.Context) error {
+		if err := GetContext(c).Session.Regenerate(); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	e.POST("/destroy", func(c echo.Context) error {
+		GetContext(c).Session.Destroy()
+		return c.NoContent(http.StatusOK)
+	})
+	e.POST("/flash", func(c echo.Context) error {
+		GetContext(c).Session.AddFlash(c.QueryParam("v"))
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/flashes", func(c echo.Context) error {
+		return c.String(http.StatusOK, strings.Join(GetContext(c).Session.Flashes(), ","))
+	})
+	return e
+}
+
+// serveSession serves a request with the session cookie and returns the
+// response and the session cookie set by it, if any.
+func serveSession(e *Server, method, target string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	req := httptest.NewRequest(method, target, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == defaultSessionCookieName {
+			return rec, c
+		}
+	}
+	return rec, nil
+}
+
+func newSessionTestStores(t *testing.T) map[string]func() SessionStore {
+	return map[string]func() SessionStore{
+		"cookie": func() SessionStore {
+			store, err := NewCookieSessionStore([]byte("0123456789abcdef"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+		"memory": func() SessionStore {
+			return NewMemorySessionStore()
+		},
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	for name, newStore := range newSessionTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			e := newSessionTestServer(t, SessionOptions{Store: newStore()})
+
+			if _, cookie := serveSession(e, http.MethodGet, "/get", nil); cookie != nil {
+				t.Errorf("unmodified new session set a cookie")
+			}
+			_, cookie := serveSession(e, http.MethodPost, "/set?v=a", nil)
+			if cookie == nil || cookie.Value == "" {
+				t.Fatalf("modified session set no cookie")
+			}
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+				t.Errorf("cookie = %+v, want an HTTP only, SameSite=Lax persistent cookie", cookie)
+			}
+			if rec, _ := serveSession(e, http.MethodGet, "/get", cookie); rec.Body.String() != "a" {
+				t.Errorf("value = %q, want a", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSessionInvalidCookie(t *testing.T) {
+	for name, newStore := range newSessionTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			e := newSessionTestServer(t, SessionOptions{Store: newStore()})
+			_, cookie := serveSession(e, http.MethodPost, "/set?v=a", nil)
+
+			tampered := *cookie
+			b := []byte(tampered.Value)
+			b[len(b)/2] ^= 1
+			tampered.Value = string(b)
+			rec, cleared := serveSession(e, http.MethodGet, "/get", &tampered)
+			if rec.Body.String() != "" {
+				t.Errorf("value of a tampered session = %q, want none", rec.Body.String())
+			}
+			if cleared == nil || cleared.MaxAge >= 0 {
+				t.Errorf("cookie of a tampered session = %+v, want it cleared", cleared)
+			}
+		})
+	}
+
+	// Sessions sealed with an unknown key are treated as missing.
+	store, _ := NewCookieSessionStore([]byte("0123456789abcdef"))
+	other, _ := NewCookieSessionStore([]byte("fedcba9876543210"))
+	_, cookie := serveSession(newSessionTestServer(t, SessionOptions{Store: store}), http.MethodPost, "/set?v=a", nil)
+	if rec, _ := serveSession(newSessionTestServer(t, SessionOptions{Store: other}), http.MethodGet, "/get", cookie); rec.Body.String() != "" {
+		t.Errorf("value of a session with an unknown key = %q, want none", rec.Body.String())
+	}
+	rotated, _ := NewCookieSessionStore([]byte("fedcba9876543210"), []byte("0123456789abcdef"))
+	if rec, _ := serveSession(newSessionTestServer(t, SessionOptions{Store: rotated}), http.MethodGet, "/get", cookie); rec.Body.String() != "a" {
+		t.Errorf("value of a session with a rotated key = %q, want a", rec.Body.String())
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SessionOptions
+	}{
+		{name: "idle timeout", opts: SessionOptions{IdleTimeout: 20 * time.Millisecond}},
+		{name: "absolute timeout", opts: SessionOptions{AbsoluteTimeout: 20 * time.Millisecond}},
+	}
+	for name, newStore := range newSessionTestStores(t) {
+		for _, tt := range tests {
+			t.Run(name+" "+tt.name, func(t *testing.T) {
+				tt.opts.Store = newStore()
+				e := newSessionTestServer(t, tt.opts)
+				_, cookie := serveSession(e, http.MethodPost, "/set?v=a", nil)
+				if rec, _ := serveSession(e, http.MethodGet, "/get", cookie); rec.Body.String() != "a" {
+					t.Fatalf("value = %q, want a", rec.Body.String())
+				}
+
+				time.Sleep(30 * time.Millisecond)
+				rec, cleared := serveSession(e, http.MethodGet, "/get", cookie)
+				if rec.Body.String() != "" {
+					t.Errorf("value of an expired session = %q, want none", rec.Body.String())
+				}
+				if cleared == nil || cleared.MaxAge >= 0 {
+					t.Errorf("cookie of an expired session = %+v, want it cleared", cleared)
+				}
+			})
+		}
+	}
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	for name, newStore := range newSessionTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			e := newSessionTestServer(t, SessionOptions{Store: newStore()})
+			_, cookie := serveSession(e, http.MethodPost, "/set?v=a", nil)
+
+			_, regenerated := serveSession(e, http.MethodPost, "/regenerate", cookie)
+			if regenerated == nil || regenerated.Value == cookie.Value {
+				t.Fatalf("regenerated cookie = %+v, want a new cookie", regenerated)
+			}
+			if rec, _ := serveSession(e, http.MethodGet, "/get", regenerated); rec.Body.String() != "a" {
+				t.Errorf("value of the regenerated session = %q, want a", rec.Body.String())
+			}
+
+			// Cookie sessions cannot be revoked, see NewCookieSessionStore.
+			want := ""
+			if name == "cookie" {
+				want = "a"
+			}
+			if rec, _ := serveSession(e, http.MethodGet, "/get", cookie); rec.Body.String() != want {
+				t.Errorf("value of the old session = %q, want %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestSessionDestroy(t *testing.T) {
+	for name, newStore := range newSessionTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			e := newSessionTestServer(t, SessionOptions{Store: newStore()})
+			_, cookie := serveSession(e, http.MethodPost, "/set?v=a", nil)
+
+			_, cleared := serveSession(e, http.MethodPost, "/destroy", cookie)
+			if cleared == nil || cleared.MaxAge >= 0 || cleared.Value != "" {
+				t.Fatalf("cookie of a destroyed session = %+v, want it cleared", cleared)
+			}
+
+			want := ""
+			if name == "cookie" {
+				want = "a"
+			}
+			if rec, _ := serveSession(e, http.MethodGet, "/get", cookie); rec.Body.String() != want {
+				t.Errorf("value of the destroyed session = %q, want %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestSessionFlashes(t *testing.T) {
+	for name, newStore := range newSessionTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			e := newSessionTestServer(t, SessionOptions{Store: newStore()})
+			_, cookie := serveSession(e, http.MethodPost, "/flash?v=a", nil)
+			_, cookie = serveSession(e, http.MethodPost, "/flash?v=b", cookie)
+
+			rec, next := serveSession(e, http.MethodGet, "/flashes", cookie)
+			if rec.Body.String() != "a,b" {
+				t.Errorf("flashes = %q, want a,b", rec.Body.String())
+			}
+			if next != nil {
+				cookie = next
+			}
+			if rec, _ := serveSession(e, http.MethodGet, "/flashes", cookie); rec.Body.String() != "" {
+				t.Errorf("flashes after reading them = %q, want none", rec.Body.String())
+			}
+		})
+	}
+}
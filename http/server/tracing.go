@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/gpahal/golib/tracing"
+	"github.com/labstack/echo/v4"
+)
+
+func newTracingMiddleware(tracer tracing.Tracer) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := tracing.Extract(req.Context(), req.Header)
+			if tracer == nil {
+				c.SetRequest(req.WithContext(ctx))
+				return next(c)
+			}
+
+			ctx, span := tracer.Start(ctx, req.Method+" "+c.Path(), tracing.SpanKindServer)
+			defer span.End()
+
+			span.SetAttribute("http.request.method", req.Method)
+			span.SetAttribute("http.route", c.Path())
+			span.SetAttribute("url.path", req.URL.Path)
+			if requestId := c.Response().Header().Get(echo.HeaderXRequestID); requestId != "" {
+				span.SetAttribute("request_id", requestId)
+			}
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.SetAttribute("http.response.status_code", responseStatus(c, err))
+			return err
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+// FieldError is a single validation failure of a request field.
+type FieldError struct {
+	// Field is the path of the field using JSON field names, e.g.
+	// "address.zip" or "items[0].name".
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// BindAndValidate binds the body, query params, headers and path params of
+// the request into a new T, whatever the request method, and validates it
+// with the server validator. Validation failures are returned as a 422
+// Problem with the field errors in its "errors" extension member.
+func BindAndValidate[T any](c echo.Context) (T, error) {
+	var v T
+	if err := bindRequest(c, &v); err != nil {
+		return v, err
+	}
+	if err := Validate(c, &v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// Validate validates v with the server validator. It does nothing if the
+// server has no validator or v is not a struct.
+func Validate(c echo.Context, v any) error {
+	sctx := GetContext(c)
+	if sctx.Validator == nil || !isStruct(v) {
+		return nil
+	}
+
+	err := sctx.Validator.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	fieldErrors := make([]FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		fieldErrors = append(fieldErrors, newFieldError(reflect.TypeOf(v), fe, sctx.Translator))
+	}
+	return NewProblem(http.StatusUnprocessableEntity, "Request validation failed").
+		WithExtension("errors", fieldErrors).
+		SetInternal(err)
+}
+
+func newFieldError(typ reflect.Type, fe validator.FieldError, translator ut.Translator) FieldError {
+	field := jsonFieldPath(typ, fe.StructNamespace())
+	message := ""
+	if translator != nil {
+		// Translate falls back to the untranslated error if the tag has no
+		// registered translation.
+		if translated := fe.Translate(translator); translated != fe.Error() {
+			message = translated
+		}
+	}
+	if message == "" {
+		message = fieldErrorMessage(fe)
+	}
+
+	return FieldError{Field: field, Tag: fe.Tag(), Param: fe.Param(), Message: message}
+}
+
+// findTranslator returns the translator of uni best matching the
+// Accept-Language header, or the fallback translator of uni.
+func findTranslator(uni *ut.UniversalTranslator, acceptLanguage string) ut.Translator {
+	if uni == nil {
+		return nil
+	}
+
+	type language struct {
+		locale string
+		q      float64
+	}
+	var languages []language
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if qs, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(qs, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		locale := strings.ReplaceAll(tag, "-", "_")
+		languages = append(languages, language{locale: locale, q: q})
+		if base, _, ok := strings.Cut(locale, "_"); ok {
+			languages = append(languages, language{locale: base, q: q})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].q > languages[j].q })
+
+	locales := make([]string, len(languages))
+	for i, l := range languages {
+		locales[i] = l.locale
+	}
+	translator, _ := uni.FindTranslator(locales...)
+	return translator
+}
+
+// jsonFieldPath converts a validator struct namespace like
+// "Request.Items[0].Name" to a path using the JSON names of the fields of typ,
+// like "items[0].name".
+func jsonFieldPath(typ reflect.Type, structNamespace string) string {
+	segments := strings.Split(structNamespace, ".")
+	if len(segments) > 1 {
+		// The first segment is the name of the top level struct.
+		segments = segments[1:]
+	}
+
+	path := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		name, indexes := segment, ""
+		if i := strings.IndexByte(segment, '['); i >= 0 {
+			name, indexes = segment[:i], segment[i:]
+		}
+
+		typ = derefType(typ)
+		if typ != nil && typ.Kind() == reflect.Struct {
+			if sf, ok := typ.FieldByName(name); ok {
+				name = fieldName(sf)
+				typ = sf.Type
+			} else {
+				typ = nil
+			}
+		} else {
+			typ = nil
+		}
+
+		// Step into the element type once per index, e.g. twice for
+		// "Matrix[0][1]".
+		for i := strings.Count(indexes, "["); i > 0 && typ != nil; i-- {
+			typ = derefType(typ)
+			switch typ.Kind() {
+			case reflect.Slice, reflect.Array, reflect.Map:
+				typ = typ.Elem()
+			default:
+				typ = nil
+			}
+		}
+
+		path = append(path, name+indexes)
+	}
+	return strings.Join(path, ".")
+}
+
+// fieldName returns the name of a struct field as seen by clients: its JSON
+// name, falling back to its query, path param or form name and finally its Go
+// name.
+func fieldName(sf reflect.StructField) string {
+	for _, tag := range []string{"json", "query", "param", "form"} {
+		name, _, _ := strings.Cut(sf.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func derefType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// fieldErrorMessage returns a human readable message for the common
+// validator tags.
+func fieldErrorMessage(fe validator.FieldError) string {
+	param := fe.Param()
+	switch fe.Tag() {
+	case "required", "required_if", "required_unless", "required_with", "required_with_all", "required_without", "required_without_all":
+		return "is required"
+	case "excluded_if", "excluded_unless", "excluded_with", "excluded_with_all", "excluded_without", "excluded_without_all":
+		return "must not be set"
+	case "email":
+		return "must be a valid email address"
+	case "url", "http_url":
+		return "must be a valid URL"
+	case "uri":
+		return "must be a valid URI"
+	case "uuid", "uuid3", "uuid4", "uuid5":
+		return "must be a valid UUID"
+	case "ip", "ipv4", "ipv6":
+		return "must be a valid IP address"
+	case "datetime":
+		return fmt.Sprintf("must be a datetime in the format %s", param)
+	case "alpha":
+		return "must contain only letters"
+	case "alphanum":
+		return "must contain only letters and numbers"
+	case "numeric", "number":
+		return "must be a number"
+	case "boolean":
+		return "must be a boolean"
+	case "json":
+		return "must be valid JSON"
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.Join(strings.Fields(param), ", "))
+	case "contains":
+		return fmt.Sprintf("must contain %q", param)
+	case "excludes":
+		return fmt.Sprintf("must not contain %q", param)
+	case "startswith":
+		return fmt.Sprintf("must start with %q", param)
+	case "endswith":
+		return fmt.Sprintf("must end with %q", param)
+	case "unique":
+		return "must contain unique values"
+	case "eqfield":
+		return fmt.Sprintf("must be equal to %s", param)
+	case "nefield":
+		return fmt.Sprintf("must not be equal to %s", param)
+	case "len", "min", "max", "gt", "gte", "lt", "lte":
+		return sizeMessage(fe.Kind(), fe.Tag(), param)
+	case "eq":
+		return fmt.Sprintf("must be equal to %s", param)
+	case "ne":
+		return fmt.Sprintf("must not be equal to %s", param)
+	default:
+		if param != "" {
+			return fmt.Sprintf("failed the %s=%s validation", fe.Tag(), param)
+		}
+		return fmt.Sprintf("failed the %s validation", fe.Tag())
+	}
+}
+
+var (
+	sizeMessagePrefixes = map[string][2]string{
+		"len": {"must be exactly", "must contain exactly"},
+		"min": {"must be at least", "must contain at least"},
+		"max": {"must be at most", "must contain at most"},
+		"gt":  {"must be greater than", "must contain more than"},
+		"gte": {"must be greater than or equal to", "must contain at least"},
+		"lt":  {"must be less than", "must contain fewer than"},
+		"lte": {"must be less than or equal to", "must contain at most"},
+	}
+)
+
+// sizeMessage returns the message of a size comparison tag, which compares
+// the length of strings and collections and the value of numbers.
+func sizeMessage(kind reflect.Kind, tag, param string) string {
+	prefixes := sizeMessagePrefixes[tag]
+	switch kind {
+	case reflect.String:
+		return fmt.Sprintf("%s %s characters long", prefixes[0], param)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("%s %s items", prefixes[1], param)
+	default:
+		return fmt.Sprintf("%s %s", prefixes[0], param)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+type bindAndValidateRequest struct {
+	ID    int    `param:"id" validate:"required"`
+	Page  int    `query:"page" validate:"gte=1"`
+	Name  string `json:"name" validate:"required"`
+	Email string `json:"email" validate:"omitempty,email"`
+}
+
+func TestBindAndValidate(t *testing.T) {
+	e := NewWithOptions(Options{LoggerWriter: io.Discard, Validator: validator.New(), ProblemDetails: true})
+	var got bindAndValidateRequest
+	handler := func(c echo.Context) error {
+		req, err := BindAndValidate[bindAndValidateRequest](c)
+		if err != nil {
+			return err
+		}
+		got = req
+		return c.NoContent(http.StatusNoContent)
+	}
+	e.POST("/users/:id", handler)
+	e.PUT("/users/:id", handler)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		want       bindAndValidateRequest
+		wantFields []string
+	}{
+		{
+			name:       "post binds query params",
+			method:     http.MethodPost,
+			target:     "/users/7?page=3",
+			body:       `{"name":"a"}`,
+			wantStatus: http.StatusNoContent,
+			want:       bindAndValidateRequest{ID: 7, Page: 3, Name: "a"},
+		},
+		{
+			name:       "put binds query params",
+			method:     http.MethodPut,
+			target:     "/users/7?page=2",
+			body:       `{"name":"b"}`,
+			wantStatus: http.StatusNoContent,
+			want:       bindAndValidateRequest{ID: 7, Page: 2, Name: "b"},
+		},
+		{
+			name:       "invalid fields",
+			method:     http.MethodPost,
+			target:     "/users/7?page=0",
+			body:       `{"email":"x"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"page", "name", "email"},
+		},
+		{
+			name:       "malformed body",
+			method:     http.MethodPost,
+			target:     "/users/7?page=1",
+			body:       `{`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = bindAndValidateRequest{}
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusNoContent && got != tt.want {
+				t.Errorf("bound request = %+v, want %+v", got, tt.want)
+			}
+			if tt.wantFields == nil {
+				return
+			}
+
+			var problem struct {
+				Errors []FieldError `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			fields := make([]string, 0, len(problem.Errors))
+			for _, fe := range problem.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("field errors = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
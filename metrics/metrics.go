@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"io"
+)
+
+var (
+	// DefaultBuckets are the default histogram buckets, in seconds, tailored
+	// to measure request latencies.
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// Counter is a monotonically increasing value partitioned by label values.
+type Counter interface {
+	Inc(labelValues ...string)
+	Add(v float64, labelValues ...string)
+}
+
+// Gauge is a value that can go up and down partitioned by label values.
+type Gauge interface {
+	Set(v float64, labelValues ...string)
+	Add(v float64, labelValues ...string)
+	Inc(labelValues ...string)
+	Dec(labelValues ...string)
+}
+
+// Histogram samples observations into buckets partitioned by label values.
+type Histogram interface {
+	Observe(v float64, labelValues ...string)
+}
+
+// Registry creates metrics. Label values passed to a metric must match the
+// label names it was created with, in order. Asking for a metric that already
+// exists with the same type and label names returns the existing metric.
+//
+// Registry can be implemented on top of other metrics libraries;
+// NewRegistry returns an in-memory implementation.
+type Registry interface {
+	Counter(name, help string, labelNames ...string) Counter
+	Gauge(name, help string, labelNames ...string) Gauge
+	Histogram(name, help string, buckets []float64, labelNames ...string) Histogram
+}
+
+// Exposer is implemented by registries that can write their metrics in the
+// Prometheus text exposition format.
+type Exposer interface {
+	WritePrometheus(w io.Writer) error
+}
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type metricType string
+
+const (
+	metricTypeCounter   metricType = "counter"
+	metricTypeGauge     metricType = "gauge"
+	metricTypeHistogram metricType = "histogram"
+
+	labelValuesSep = "\xff"
+)
+
+type series struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	count       uint64
+}
+
+type metric struct {
+	name       string
+	help       string
+	typ        metricType
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, labelValuesSep)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if m.typ == metricTypeHistogram {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(v float64, labelValues []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value += v
+}
+
+func (m *metric) set(v float64, labelValues []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value = v
+}
+
+func (m *metric) observe(v float64, labelValues []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(labelValues)
+	for i, upper := range m.buckets {
+		if v <= upper {
+			s.buckets[i]++
+		}
+	}
+	s.value += v
+	s.count++
+}
+
+type counter struct{ *metric }
+
+func (c counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+func (c counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metric %s: counter cannot decrease", c.name))
+	}
+	c.add(v, labelValues)
+}
+
+type gauge struct{ *metric }
+
+func (g gauge) Set(v float64, labelValues ...string) {
+	g.set(v, labelValues)
+}
+
+func (g gauge) Add(v float64, labelValues ...string) {
+	g.add(v, labelValues)
+}
+
+func (g gauge) Inc(labelValues ...string) {
+	g.add(1, labelValues)
+}
+
+func (g gauge) Dec(labelValues ...string) {
+	g.add(-1, labelValues)
+}
+
+type histogram struct{ *metric }
+
+func (h histogram) Observe(v float64, labelValues ...string) {
+	h.observe(v, labelValues)
+}
+
+// MemoryRegistry is an in-memory Registry which can write its metrics in the
+// Prometheus text exposition format. It is safe for concurrent use.
+type MemoryRegistry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+func NewRegistry() *MemoryRegistry {
+	return &MemoryRegistry{metrics: make(map[string]*metric)}
+}
+
+func (r *MemoryRegistry) Counter(name, help string, labelNames ...string) Counter {
+	return counter{r.register(name, help, metricTypeCounter, nil, labelNames)}
+}
+
+func (r *MemoryRegistry) Gauge(name, help string, labelNames ...string) Gauge {
+	return gauge{r.register(name, help, metricTypeGauge, nil, labelNames)}
+}
+
+func (r *MemoryRegistry) Histogram(name, help string, buckets []float64, labelNames ...string) Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+	return histogram{r.register(name, help, metricTypeHistogram, buckets, labelNames)}
+}
+
+func (r *MemoryRegistry) register(name, help string, typ metricType, buckets []float64, labelNames []string) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		if m.typ != typ || !slices.Equal(m.labelNames, labelNames) || !slices.Equal(m.buckets, buckets) {
+			panic(fmt.Sprintf("metric %s already registered with a different type, labels or buckets", name))
+		}
+		return m
+	}
+
+	m := &metric{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: slices.Clone(labelNames),
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// Value returns the current value of a counter or gauge series, or the sum of
+// observations of a histogram series. It is mainly useful in tests.
+func (r *MemoryRegistry) Value(name string, labelValues ...string) float64 {
+	r.mu.Lock()
+	m, ok := r.metrics[name]
+	r.mu.Unlock()
+	if !ok {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.series[strings.Join(labelValues, labelValuesSep)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// Count returns the number of observations of a histogram series. It is
+// mainly useful in tests.
+func (r *MemoryRegistry) Count(name string, labelValues ...string) uint64 {
+	r.mu.Lock()
+	m, ok := r.metrics[name]
+	r.mu.Unlock()
+	if !ok {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.series[strings.Join(labelValues, labelValuesSep)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (r *MemoryRegistry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	ms := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		ms = append(ms, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].name < ms[j].name })
+	for _, m := range ms {
+		if err := writeMetric(w, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests.", "method")
+	c.Inc("GET")
+	c.Add(2.5, "GET")
+	c.Inc("POST")
+
+	if got := r.Value("requests_total", "GET"); got != 3.5 {
+		t.Errorf("GET value = %v, want 3.5", got)
+	}
+	if got := r.Value("requests_total", "POST"); got != 1 {
+		t.Errorf("POST value = %v, want 1", got)
+	}
+	if got := r.Value("requests_total", "PUT"); got != 0 {
+		t.Errorf("PUT value = %v, want 0", got)
+	}
+}
+
+func TestCounterDecreasePanics(t *testing.T) {
+	c := NewRegistry().Counter("requests_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("Add(-1) did not panic")
+		}
+	}()
+	c.Add(-1)
+}
+
+func TestGauge(t *testing.T) {
+	r := NewRegistry()
+	g := r.Gauge("in_flight", "")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(0.5)
+	if got := r.Value("in_flight"); got != 1.5 {
+		t.Errorf("value = %v, want 1.5", got)
+	}
+	g.Set(-2)
+	if got := r.Value("in_flight"); got != -2 {
+		t.Errorf("value after Set = %v, want -2", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("duration_seconds", "", []float64{1, 0.1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.Observe(v, "/users")
+	}
+
+	if got := r.Count("duration_seconds", "/users"); got != 4 {
+		t.Errorf("count = %d, want 4", got)
+	}
+	if got := r.Value("duration_seconds", "/users"); got != 2.65 {
+		t.Errorf("sum = %v, want 2.65", got)
+	}
+}
+
+func TestRegisterExisting(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "", "method").Inc("GET")
+	r.Counter("requests_total", "", "method").Inc("GET")
+	if got := r.Value("requests_total", "GET"); got != 2 {
+		t.Errorf("value = %v, want 2", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering with different labels did not panic")
+		}
+	}()
+	r.Counter("requests_total", "", "route")
+}
+
+func TestLabelValuesMismatchPanics(t *testing.T) {
+	c := NewRegistry().Counter("requests_total", "", "method", "route")
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with missing label values did not panic")
+		}
+	}()
+	c.Inc("GET")
+}
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "Total number of requests.\nAll of them.", "method", "path").Inc("GET", `/a"b\c`)
+	r.Counter("requests_total", "", "method", "path").Add(2, "DELETE", "/")
+	r.Gauge("in_flight", "").Set(3)
+	h := r.Histogram("duration_seconds", "Request durations.", []float64{0.1, 1}, "method")
+	h.Observe(0.05, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(5, "GET")
+
+	var sb strings.Builder
+	if err := r.WritePrometheus(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP duration_seconds Request durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{method="GET",le="0.1"} 1
+duration_seconds_bucket{method="GET",le="1"} 2
+duration_seconds_bucket{method="GET",le="+Inf"} 3
+duration_seconds_sum{method="GET"} 5.55
+duration_seconds_count{method="GET"} 3
+# TYPE in_flight gauge
+in_flight 3
+# HELP requests_total Total number of requests.\nAll of them.
+# TYPE requests_total counter
+requests_total{method="DELETE",path="/"} 2
+requests_total{method="GET",path="/a\"b\\c"} 1
+`
+	if got := sb.String(); got != want {
+		t.Errorf("WritePrometheus() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler(r).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if want := "# TYPE requests_total counter\nrequests_total 1\n"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
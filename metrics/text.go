@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ContentType is the content type of the Prometheus text exposition
+	// format.
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+func writeMetric(w io.Writer, m *metric) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sb strings.Builder
+	if m.help != "" {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	}
+	fmt.Fprintf(&sb, "# TYPE %s %s\n", m.name, m.typ)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != metricTypeHistogram {
+			writeSample(&sb, m.name, m.labelNames, s.labelValues, "", "", s.value)
+			continue
+		}
+
+		for i, upper := range m.buckets {
+			writeSample(&sb, m.name+"_bucket", m.labelNames, s.labelValues, "le", formatFloat(upper), float64(s.buckets[i]))
+		}
+		writeSample(&sb, m.name+"_bucket", m.labelNames, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(&sb, m.name+"_sum", m.labelNames, s.labelValues, "", "", s.value)
+		writeSample(&sb, m.name+"_count", m.labelNames, s.labelValues, "", "", float64(s.count))
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeSample(sb *strings.Builder, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	sb.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		sb.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(sb, "%s=\"%s\"", labelName, escapeLabelValue(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(sb, "%s=\"%s\"", extraName, extraValue)
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(formatFloat(value))
+	sb.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+// Handler returns an http.Handler which writes the metrics of exposer in the
+// Prometheus text exposition format.
+func Handler(exposer Exposer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := exposer.WritePrometheus(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+
+	traceparentVersion = "00"
+)
+
+// Inject writes the span context carried by ctx into h as W3C traceparent and
+// tracestate headers. It does nothing if ctx carries no valid span context.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	h.Set(HeaderTraceparent, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		h.Set(HeaderTracestate, sc.TraceState)
+	} else {
+		h.Del(HeaderTracestate)
+	}
+}
+
+// Extract reads W3C traceparent and tracestate headers from h and returns a
+// copy of ctx carrying the remote span context. ctx is returned unchanged if
+// the headers are missing or malformed.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(HeaderTraceparent))
+	if err != nil {
+		return ctx
+	}
+
+	sc.TraceState = strings.Join(h.Values(HeaderTracestate), ",")
+	sc.Remote = true
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// FormatTraceparent returns the traceparent header value for sc.
+func FormatTraceparent(sc SpanContext) string {
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, sc.TraceID, sc.SpanID, byte(sc.Flags))
+}
+
+// ParseTraceparent parses a traceparent header value.
+func ParseTraceparent(s string) (SpanContext, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 {
+		return SpanContext{}, errors.Errorf("invalid traceparent %q", s)
+	}
+
+	version := parts[0]
+	if len(version) != 2 || version == "ff" || !isLowerHex(version) {
+		return SpanContext{}, errors.Errorf("invalid traceparent version %q", version)
+	}
+	// Version 00 has exactly four fields, future versions may append more.
+	if version == traceparentVersion && len(parts) != 4 {
+		return SpanContext{}, errors.Errorf("invalid traceparent %q", s)
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || !isLowerHex(parts[1]) {
+		return SpanContext{}, errors.Errorf("invalid trace id %q", parts[1])
+	}
+	_, _ = hex.Decode(sc.TraceID[:], []byte(parts[1]))
+
+	if len(parts[2]) != 16 || !isLowerHex(parts[2]) {
+		return SpanContext{}, errors.Errorf("invalid parent id %q", parts[2])
+	}
+	_, _ = hex.Decode(sc.SpanID[:], []byte(parts[2]))
+
+	if len(parts[3]) != 2 || !isLowerHex(parts[3]) {
+		return SpanContext{}, errors.Errorf("invalid trace flags %q", parts[3])
+	}
+	var flags [1]byte
+	_, _ = hex.Decode(flags[:], []byte(parts[3]))
+	sc.Flags = TraceFlags(flags[0])
+
+	if !sc.IsValid() {
+		return SpanContext{}, errors.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+		want    string
+	}{
+		{name: "sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "surrounding spaces", value: " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "future version with extra fields", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "empty", value: "", wantErr: true},
+		{name: "version ff", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "version 00 with extra fields", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantErr: true},
+		{name: "uppercase trace id", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "short trace id", value: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero parent id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "invalid flags", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseTraceparent(%q) = %v, want error", tt.value, sc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTraceparent(%q): %v", tt.value, err)
+			}
+			if got := FormatTraceparent(sc); got != tt.want {
+				t.Errorf("FormatTraceparent(ParseTraceparent(%q)) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	in := make(http.Header)
+	in.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Add(HeaderTracestate, "a=1")
+	in.Add(HeaderTracestate, "b=2")
+
+	ctx := Extract(context.Background(), in)
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.Remote || !sc.IsSampled() {
+		t.Fatalf("extracted span context = %+v, want valid, remote and sampled", sc)
+	}
+	if sc.TraceState != "a=1,b=2" {
+		t.Errorf("TraceState = %q, want %q", sc.TraceState, "a=1,b=2")
+	}
+
+	out := make(http.Header)
+	Inject(ctx, out)
+	if got := out.Get(HeaderTraceparent); got != in.Get(HeaderTraceparent) {
+		t.Errorf("injected traceparent = %q, want %q", got, in.Get(HeaderTraceparent))
+	}
+	if got := out.Get(HeaderTracestate); got != "a=1,b=2" {
+		t.Errorf("injected tracestate = %q, want %q", got, "a=1,b=2")
+	}
+}
+
+func TestExtractMalformed(t *testing.T) {
+	h := make(http.Header)
+	h.Set(HeaderTraceparent, "00-invalid")
+	ctx := Extract(context.Background(), h)
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		t.Errorf("extracted span context = %+v, want invalid", sc)
+	}
+
+	out := make(http.Header)
+	Inject(ctx, out)
+	if len(out) != 0 {
+		t.Errorf("injected headers = %v, want none", out)
+	}
+}
+
+func TestTracerParentChild(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	child.End()
+	parent.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	childData, parentData := spans[0], spans[1]
+	if childData.SpanContext.TraceID != parentData.SpanContext.TraceID {
+		t.Errorf("child trace id = %s, want %s", childData.SpanContext.TraceID, parentData.SpanContext.TraceID)
+	}
+	if childData.ParentSpanID != parentData.SpanContext.SpanID {
+		t.Errorf("child parent span id = %s, want %s", childData.ParentSpanID, parentData.SpanContext.SpanID)
+	}
+	if parentData.ParentSpanID.IsValid() {
+		t.Errorf("root parent span id = %s, want none", parentData.ParentSpanID)
+	}
+}
+
+func TestTracerNotSampled(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	h := make(http.Header)
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := tracer.Start(Extract(context.Background(), h), "span", SpanKindServer)
+	span.End()
+
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Errorf("exported %d spans of an unsampled trace, want 0", len(spans))
+	}
+}
@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// Span is a single traced operation. Implementations must be safe for
+// concurrent use.
+type Span interface {
+	SpanContext() SpanContext
+	SetName(name string)
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans. It can be implemented on top of an OpenTelemetry
+// tracer; NewTracer returns a minimal implementation which hands finished
+// spans to an Exporter.
+type Tracer interface {
+	// Start starts a span as a child of the span context carried by ctx, or as
+	// a new trace root if there is none, and returns a copy of ctx carrying
+	// the new span.
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+// SpanData is a finished span.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]any
+	Err          error
+}
+
+// Exporter receives finished, sampled spans.
+type Exporter interface {
+	ExportSpan(span SpanData)
+}
+
+type ExporterFunc func(span SpanData)
+
+func (ef ExporterFunc) ExportSpan(span SpanData) {
+	ef(span)
+}
+
+// InMemoryExporter stores exported spans in memory. It is mainly useful in
+// tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpan(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns a copy of the exported spans in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+type tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a Tracer which samples every new trace, follows the
+// sampling decision of remote parents and exports finished sampled spans to
+// exporter. A nil exporter discards spans but still propagates span contexts.
+func NewTracer(exporter Exporter) Tracer {
+	return &tracer{exporter: exporter}
+}
+
+func (t *tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Flags = FlagsSampled
+	}
+
+	s := &span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  sc,
+			ParentSpanID: parent.SpanID,
+			StartTime:    time.Now(),
+		},
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+type span struct {
+	tracer *tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *span) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+func (s *span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+func (s *span) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err
+}
+
+func (s *span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil && data.SpanContext.IsSampled() {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}